@@ -0,0 +1,34 @@
+package main
+
+import (
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/snapshot"
+)
+
+const playerSnapshotKind = "player"
+
+func savePlayer(snapshot_dir string, game_state *gamelogic.GameState) (string, error) {
+	path, err := snapshot.PlayerPath(snapshot_dir, game_state.GetUsername())
+	if err != nil {
+		return "", err
+	}
+	return path, snapshot.Save(path, playerSnapshotKind, game_state.Snapshot())
+}
+
+// loadPlayer restores the last saved game state of the player. It is only
+// what the player had back then, the server's answer to the next join
+// replaces it with what they own now.
+func loadPlayer(snapshot_dir string, game_state *gamelogic.GameState) (time.Time, error) {
+	path, err := snapshot.PlayerPath(snapshot_dir, game_state.GetUsername())
+	if err != nil {
+		return time.Time{}, err
+	}
+	var snap gamelogic.GameStateSnapshot
+	saved_at, err := snapshot.Load(path, playerSnapshotKind, &snap)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return saved_at, game_state.Restore(snap)
+}
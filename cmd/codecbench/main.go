@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	_ "github.com/bootdotdev/learn-pub-sub-starter/internal/wire"
+)
+
+var codecs = []struct {
+	name  string
+	codec pubsub.Codec
+}{
+	{"json", pubsub.JSONCodec},
+	{"gob", pubsub.GobCodec},
+	{"msgpack", pubsub.MsgpackCodec},
+	{"protobuf", pubsub.ProtobufCodec},
+}
+
+func armyOf(username string, units int) gamelogic.Player {
+	ranks := []gamelogic.UnitRank{gamelogic.RankInfantry, gamelogic.RankCavalry, gamelogic.RankArtillery}
+	locations := []gamelogic.Location{"americas", "europe", "africa", "asia", "australia", "antarctica"}
+	player := gamelogic.Player{Username: username, Units: map[int]gamelogic.Unit{}}
+	for i := 1; i <= units; i++ {
+		player.Units[i] = gamelogic.Unit{ID: i, Rank: ranks[i%len(ranks)], Location: locations[i%len(locations)]}
+	}
+	return player
+}
+
+// bench measures one message type with every codec. decode has to
+// unmarshal into a fresh value of the message's type.
+func bench[T any](w *tabwriter.Writer, name string, msg T) {
+	for _, c := range codecs {
+		data, err := c.codec.Marshal(msg)
+		if err != nil {
+			log.Fatalf("%s: marshaling %s: %v", c.name, name, err)
+		}
+
+		encode := testing.Benchmark(func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c.codec.Marshal(msg)
+			}
+		})
+		decode := testing.Benchmark(func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var out T
+				c.codec.Unmarshal(data, &out)
+			}
+		})
+
+		fmt.Fprintf(w, "%s\t%s\t%d\t%v\t%v\t%d\t\n", name, c.name, len(data),
+			time.Duration(encode.NsPerOp()), time.Duration(decode.NsPerOp()), decode.AllocsPerOp())
+	}
+}
+
+func main() {
+	units := flag.Int("units", 50, "units in each player's army")
+	flag.Parse()
+
+	attacker := armyOf("attacker", *units)
+	defender := armyOf("defender", *units)
+	moved := []gamelogic.Unit{}
+	for _, unit := range attacker.Units {
+		moved = append(moved, unit)
+		if len(moved) == 3 {
+			break
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "message\tcodec\tbytes\tencode\tdecode\tdecode allocs\t")
+	bench(w, "ArmyMove", gamelogic.ArmyMove{Player: attacker, Units: moved, ToLocation: "asia"})
+	bench(w, "RecognitionOfWar", gamelogic.RecognitionOfWar{Attacker: attacker, Defender: defender})
+	bench(w, "PlayingState", routing.PlayingState{IsPaused: true})
+	bench(w, "GameLog", routing.GameLog{CurrentTime: time.Now(), Username: "attacker", Message: gamelogic.GetMaliciousLog()})
+	w.Flush()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/eventlog"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: replay [flags]")
+	fmt.Fprintln(os.Stderr, "Steps through a game recorded by the server, one event at a time.")
+	fmt.Fprintln(os.Stderr, "Flags:")
+	flag.PrintDefaults()
+}
+
+func printHelp() {
+	fmt.Println("Possible commands:")
+	fmt.Println("* next [n]")
+	fmt.Println("* prev")
+	fmt.Println("* seek <seq|time>")
+	fmt.Println("    example:")
+	fmt.Println("    seek 2024-05-01T15:04:05Z")
+	fmt.Println("* status [username]")
+	fmt.Println("* players")
+	fmt.Println("* reset")
+	fmt.Println("* quit")
+	fmt.Println("* help")
+}
+
+// seek jumps to an event number or, failing that, a time.
+func seek(replay *eventlog.Replay, to string) error {
+	seq, err := strconv.ParseUint(to, 10, 64)
+	if err == nil {
+		replay.SeekSeq(seq)
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, to)
+	if err != nil {
+		return fmt.Errorf("'%s' is neither an event number nor a time like 2006-01-02T15:04:05Z", to)
+	}
+	replay.SeekTime(t)
+	return nil
+}
+
+func printPosition(replay *eventlog.Replay) {
+	last, ok := replay.Last()
+	if !ok {
+		fmt.Printf("At the start, %d events to go.\n", replay.Len())
+		return
+	}
+	fmt.Printf("At %s (%d/%d).\n", last.Time.Format(time.RFC3339), replay.Applied(), replay.Len())
+}
+
+func main() {
+	file := flag.String("file", eventlog.DefaultPath, "event log recorded by the server")
+	username := flag.String("u", "", "player whose state 'status' shows by default")
+	at := flag.String("at", "", "event number or time to start at")
+	flag.Usage = usage
+	flag.Parse()
+
+	events, err := eventlog.ReadAll(*file)
+	if err != nil {
+		log.Fatal("Couldn't read event log: ", err)
+	}
+	if len(events) == 0 {
+		log.Fatalf("No events recorded in '%s'.", *file)
+	}
+	fmt.Printf("Loaded %d events from %s to %s.\n", len(events), events[0].Time.Format(time.RFC3339), events[len(events)-1].Time.Format(time.RFC3339))
+
+	replay := eventlog.NewReplay(events)
+	if *at != "" {
+		err := seek(replay, *at)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	printPosition(replay)
+	printHelp()
+
+	for {
+		fmt.Println()
+		input := gamelogic.GetInput()
+		if input == nil {
+			return
+		}
+		if len(input) == 0 {
+			continue
+		}
+		if input[0] == "next" {
+			n := 1
+			if len(input) > 1 {
+				n, err = strconv.Atoi(input[1])
+				if err != nil || n < 1 {
+					fmt.Println("Usage: next [n]")
+					continue
+				}
+			}
+			for ; n > 0; n-- {
+				event, ok := replay.Step()
+				if !ok {
+					fmt.Println("That was the last event.")
+					break
+				}
+				fmt.Println(event)
+			}
+		} else if input[0] == "prev" {
+			last, ok := replay.Last()
+			if !ok {
+				fmt.Println("Already at the start.")
+				continue
+			}
+			replay.SeekSeq(last.Seq - 1)
+			printPosition(replay)
+		} else if input[0] == "seek" {
+			if len(input) < 2 {
+				fmt.Println("Usage: seek <seq|time>")
+				continue
+			}
+			err := seek(replay, input[1])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			printPosition(replay)
+		} else if input[0] == "status" {
+			player := *username
+			if len(input) > 1 {
+				player = input[1]
+			}
+			if player == "" {
+				fmt.Println("Usage: status <username>, or start the replay with -u")
+				continue
+			}
+			replay.GameState(player).CommandStatus()
+		} else if input[0] == "players" {
+			usernames := replay.Usernames()
+			if len(usernames) == 0 {
+				fmt.Println("Nobody has joined yet.")
+			}
+			for _, player := range usernames {
+				fmt.Printf("* %s: %d units\n", player, len(replay.GameState(player).GetPlayerSnap().Units))
+			}
+		} else if input[0] == "reset" {
+			replay.Reset()
+			printPosition(replay)
+		} else if input[0] == "quit" {
+			return
+		} else if input[0] == "help" {
+			printHelp()
+		} else {
+			fmt.Println("Unknown command.")
+		}
+	}
+}
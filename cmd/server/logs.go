@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/logstore"
+)
+
+// parseLogsCommand reads the arguments of the 'logs' command. Whatever isn't
+// a flag is the text to search for.
+func parseLogsCommand(args []string) (logstore.Query, bool, error) {
+	flags := flag.NewFlagSet("logs", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	username := flags.String("u", "", "")
+	since := flags.String("since", "", "")
+	until := flags.String("until", "", "")
+	limit := flags.Int("n", 20, "")
+	follow := flags.Bool("f", false, "")
+	err := flags.Parse(args)
+	if err != nil {
+		return logstore.Query{}, false, err
+	}
+
+	query := logstore.Query{
+		Username: *username,
+		Contains: strings.Join(flags.Args(), " "),
+		Limit:    *limit,
+	}
+	query.From, err = parseLogsTime(*since)
+	if err != nil {
+		return logstore.Query{}, false, err
+	}
+	query.To, err = parseLogsTime(*until)
+	if err != nil {
+		return logstore.Query{}, false, err
+	}
+
+	return query, *follow, nil
+}
+
+// parseLogsTime takes either a time like 2024-05-01T15:04:05Z or how long
+// ago, like 10m.
+func parseLogsTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	ago, err := time.ParseDuration(value)
+	if err == nil {
+		return time.Now().Add(-ago), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("'%s' is neither a time like 2006-01-02T15:04:05Z nor a duration like 10m", value)
+	}
+	return t, nil
+}
+
+func printLogsUsage() {
+	fmt.Println("Usage: logs [-u username] [-since 10m|time] [-until 5m|time] [-n 20] [-f] [text]")
+	fmt.Println("  -n shows that many of the newest matching logs, 0 shows all of them.")
+	fmt.Println("  -f keeps printing new matching logs until you press Enter.")
+}
+
+// followLogs prints new matching logs until the next line of input.
+func followLogs(ctx context.Context, store *logstore.Store, query logstore.Query, inputs *gamelogic.InputReader) {
+	// Already printed entries are in the past, only new ones are followed.
+	query.From = time.Time{}
+	query.Limit = 0
+	follower := store.Follow(query)
+	defer store.Unfollow(follower)
+
+	fmt.Println("Following game logs, press Enter to stop.")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-inputs.Next():
+			if !ok {
+				<-ctx.Done()
+			}
+			if dropped := follower.Dropped(); dropped > 0 {
+				fmt.Printf("%d logs were skipped because they came in too fast.\n", dropped)
+			}
+			return
+		case entry, ok := <-follower.Entries():
+			if !ok {
+				return
+			}
+			fmt.Println(entry)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/snapshot"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/world"
+)
+
+const worldSnapshotKind = "world"
+
+func saveWorld(snapshot_dir string, game_world *world.World) error {
+	return snapshot.Save(snapshot.WorldPath(snapshot_dir), worldSnapshotKind, game_world.Snapshot())
+}
+
+// loadWorld restores the world from its snapshot and sends every player
+// their units again, so clients that are already running catch up.
+func loadWorld(snapshot_dir string, game_world *world.World, publisher pubsub.Publisher) (world.Snapshot, error) {
+	var snap world.Snapshot
+	_, err := snapshot.Load(snapshot.WorldPath(snapshot_dir), worldSnapshotKind, &snap)
+	if err != nil {
+		return world.Snapshot{}, err
+	}
+	publishDeltas(publisher, game_world.Restore(snap))
+	announceGameOver(game_world, publisher)
+	return snap, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/world"
+)
+
+// turnClock runs turn mode: it announces every turn on peril_direct and
+// ends it once its deadline passes. The clock stops while the game is
+// paused.
+type turnClock struct {
+	world     *world.World
+	publisher pubsub.Publisher
+	deadline  time.Duration
+
+	mu        sync.Mutex
+	timer     *time.Timer
+	armed     int
+	ends      time.Time
+	paused    bool
+	remaining time.Duration
+}
+
+func newTurnClock(game_world *world.World, publisher pubsub.Publisher, deadline time.Duration) *turnClock {
+	return &turnClock{world: game_world, publisher: publisher, deadline: deadline}
+}
+
+// start switches the world to turn mode and announces the first turn.
+func (c *turnClock) start() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	turn := c.world.StartTurns()
+	c.arm(c.deadline)
+	c.announce(turn, routing.TurnPhaseOrders)
+	return turn
+}
+
+// end carries out the current turn and starts the next one.
+func (c *turnClock) end() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.endTurn()
+}
+
+// stop carries out the current turn and switches turns off.
+func (c *turnClock) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	turn := c.world.Turn()
+	if turn == 0 {
+		return
+	}
+	c.disarm()
+	c.announce(turn, routing.TurnPhaseResolving)
+	publishDeltas(c.publisher, c.world.StopTurns())
+	announceGameOver(c.world, c.publisher)
+	c.announce(0, "")
+}
+
+func (c *turnClock) pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused {
+		return
+	}
+	c.paused = true
+	if c.timer != nil {
+		c.remaining = time.Until(c.ends)
+		c.disarm()
+	}
+}
+
+// resume starts the clock again with whatever was left of the turn, and
+// tells clients the new deadline.
+func (c *turnClock) resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.paused {
+		return
+	}
+	c.paused = false
+	turn := c.world.Turn()
+	if turn == 0 {
+		return
+	}
+	if c.remaining > 0 {
+		c.arm(c.remaining)
+		c.remaining = 0
+	}
+	c.announce(turn, routing.TurnPhaseOrders)
+}
+
+// over switches turns off once the game is over, nothing is left to order.
+func (c *turnClock) over() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gameOver()
+}
+
+func (c *turnClock) gameOver() {
+	c.disarm()
+	if c.world.Turn() == 0 {
+		return
+	}
+	c.world.StopTurns()
+	c.announce(0, "")
+}
+
+// close stops the clock for good without ending the turn.
+func (c *turnClock) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = true
+	c.disarm()
+}
+
+func (c *turnClock) endTurn() {
+	turn := c.world.Turn()
+	if turn == 0 {
+		return
+	}
+	c.disarm()
+	c.announce(turn, routing.TurnPhaseResolving)
+	publishDeltas(c.publisher, c.world.EndTurn())
+	if announceGameOver(c.world, c.publisher) {
+		c.gameOver()
+		return
+	}
+	if c.paused {
+		c.remaining = c.deadline
+	} else {
+		c.arm(c.deadline)
+	}
+	c.announce(c.world.Turn(), routing.TurnPhaseOrders)
+}
+
+// arm ends the turn after d. A zero deadline leaves ending turns to the
+// 'endturn' command.
+func (c *turnClock) arm(d time.Duration) {
+	c.disarm()
+	if c.deadline <= 0 || c.paused {
+		return
+	}
+	// A timer that fires after it was replaced must not end the new turn.
+	armed := c.armed
+	c.ends = time.Now().Add(d)
+	c.timer = time.AfterFunc(d, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.armed != armed || c.paused {
+			return
+		}
+		c.endTurn()
+	})
+}
+
+func (c *turnClock) disarm() {
+	c.armed++
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.ends = time.Time{}
+}
+
+func (c *turnClock) announce(turn int, phase routing.TurnPhase) {
+	state := routing.TurnState{Turn: turn, Phase: phase}
+	if phase == routing.TurnPhaseOrders {
+		state.Deadline = c.ends
+	}
+	err := pubsub.PublishJSON(c.publisher, string(routing.ExchangePerilDirect), string(routing.TurnKey), state)
+	if err != nil {
+		log.Printf("Couldn't announce turn %d: %v", turn, err)
+	}
+}
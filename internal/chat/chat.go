@@ -0,0 +1,241 @@
+// Package chat is the server side of the in-game chat: it checks what
+// players send against a profanity and length filter and a rate limit,
+// decides who gets each message and keeps recent ones for players who join
+// later.
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+type Options struct {
+	// MaxLength is the most characters a message can have.
+	MaxLength int
+	// Burst is how many messages a player can send at once, and a player
+	// gets one more every Refill up to Burst.
+	Burst  int
+	Refill time.Duration
+	// History is how many recent messages are kept for players who join.
+	History int
+	// Banned are words masked with asterisks, matched case-insensitively.
+	Banned []string
+}
+
+var DefaultOptions = Options{
+	MaxLength: 280,
+	Burst:     5,
+	Refill:    2 * time.Second,
+	History:   50,
+	Banned:    []string{"damn", "hell", "crap", "bastard", "bloody"},
+}
+
+var (
+	ErrEmpty       = errors.New("the message is empty")
+	ErrRateLimited = errors.New("you are sending messages too fast, slow down")
+)
+
+// Delivery is a message and the routing key it goes out with.
+type Delivery struct {
+	Key     string
+	Message routing.ChatMessage
+}
+
+// Room passes chat messages on to whoever they are for.
+type Room struct {
+	options Options
+	banned  map[string]bool
+
+	mu      sync.Mutex
+	seq     uint64
+	buckets map[string]*bucket
+	history []routing.ChatMessage
+}
+
+func NewRoom(options Options) *Room {
+	banned := map[string]bool{}
+	for _, word := range options.Banned {
+		banned[strings.ToLower(word)] = true
+	}
+	return &Room{options: options, banned: banned, buckets: map[string]*bucket{}}
+}
+
+// Post checks a message a player sent and works out where it goes. Allies
+// are the players in an alliance with the sender. A message that doesn't
+// get through returns an error for the sender.
+func (r *Room) Post(msg routing.ChatMessage, allies []string, now time.Time) ([]Delivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	text, err := r.filter(msg.Text)
+	if err != nil {
+		return nil, err
+	}
+	switch msg.Channel {
+	case routing.ChatGlobal:
+	case routing.ChatAlliance:
+		if len(allies) == 0 {
+			return nil, errors.New("you have no allies to talk to")
+		}
+	case routing.ChatDirect:
+		if msg.To == "" || msg.To == msg.From {
+			return nil, errors.New("a whisper needs someone else to go to")
+		}
+	default:
+		return nil, fmt.Errorf("there is no '%s' channel", msg.Channel)
+	}
+	if !r.allow(msg.From, now) {
+		return nil, ErrRateLimited
+	}
+
+	r.seq++
+	msg.ID = r.seq
+	msg.Text = text
+	msg.SentAt = now
+	if msg.Channel == routing.ChatAlliance {
+		// Alliance messages are kept with who they went to, so history only
+		// shows them to those players.
+		msg.To = strings.Join(allies, ",")
+	}
+	r.remember(msg)
+
+	switch msg.Channel {
+	case routing.ChatGlobal:
+		return []Delivery{{Key: routing.ChatKey(routing.ChatGlobal, ""), Message: msg}}, nil
+	case routing.ChatAlliance:
+		deliveries := []Delivery{{Key: routing.ChatKey(routing.ChatAlliance, msg.From), Message: msg}}
+		for _, ally := range allies {
+			deliveries = append(deliveries, Delivery{Key: routing.ChatKey(routing.ChatAlliance, ally), Message: msg})
+		}
+		return deliveries, nil
+	}
+	return []Delivery{
+		{Key: routing.ChatKey(routing.ChatDirect, msg.To), Message: msg},
+		{Key: routing.ChatKey(routing.ChatDirect, msg.From), Message: msg},
+	}, nil
+}
+
+// Verify refuses messages a player sends in someone else's name. The
+// sender has to be the RabbitMQ user that published it.
+func Verify(origin pubsub.Origin, msg routing.ChatMessage) error {
+	if msg.From == "" || origin.Key != routing.ChatKey(routing.ChatSend, msg.From) {
+		return fmt.Errorf("message from %s sent with key '%s'", msg.From, origin.Key)
+	}
+	if origin.UserID != msg.From {
+		return fmt.Errorf("message from %s sent by user '%s'", msg.From, origin.UserID)
+	}
+	return nil
+}
+
+// History is the recent messages a player can see, oldest first. Asking
+// for it counts against the same rate limit as sending a message.
+func (r *Room) History(username string, now time.Time) ([]routing.ChatMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.allow(username, now) {
+		return nil, ErrRateLimited
+	}
+	visible := []routing.ChatMessage{}
+	for _, msg := range r.history {
+		if CanSee(msg, username) {
+			visible = append(visible, msg)
+		}
+	}
+	return visible, nil
+}
+
+// CanSee reports whether a message was sent to username.
+func CanSee(msg routing.ChatMessage, username string) bool {
+	switch msg.Channel {
+	case routing.ChatGlobal:
+		return true
+	case routing.ChatAlliance:
+		if msg.From == username {
+			return true
+		}
+		for _, ally := range strings.Split(msg.To, ",") {
+			if ally == username {
+				return true
+			}
+		}
+	case routing.ChatDirect:
+		return msg.From == username || msg.To == username
+	}
+	return false
+}
+
+func (r *Room) remember(msg routing.ChatMessage) {
+	if r.options.History <= 0 {
+		return
+	}
+	r.history = append(r.history, msg)
+	if len(r.history) > r.options.History {
+		r.history = r.history[len(r.history)-r.options.History:]
+	}
+}
+
+// filter trims a message, refuses it if it is empty or too long and masks
+// banned words.
+func (r *Room) filter(text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", ErrEmpty
+	}
+	length := len([]rune(text))
+	if r.options.MaxLength > 0 && length > r.options.MaxLength {
+		return "", fmt.Errorf("the message is %d characters long, the most is %d", length, r.options.MaxLength)
+	}
+
+	runes := []rune(text)
+	start := -1
+	for i := 0; i <= len(runes); i++ {
+		if i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 && r.banned[strings.ToLower(string(runes[start:i]))] {
+			for j := start; j < i; j++ {
+				runes[j] = '*'
+			}
+		}
+		start = -1
+	}
+	return string(runes), nil
+}
+
+// bucket is a token bucket: it holds up to Burst messages and refills one
+// every Refill.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (r *Room) allow(username string, now time.Time) bool {
+	if r.options.Burst <= 0 {
+		return true
+	}
+	b, ok := r.buckets[username]
+	if !ok {
+		b = &bucket{tokens: float64(r.options.Burst), last: now}
+		r.buckets[username] = b
+	}
+	if r.options.Refill > 0 {
+		b.tokens += float64(now.Sub(b.last)) / float64(r.options.Refill)
+		b.tokens = min(b.tokens, float64(r.options.Burst))
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
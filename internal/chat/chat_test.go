@@ -0,0 +1,132 @@
+package chat
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func say(from, text string) routing.ChatMessage {
+	return routing.ChatMessage{Channel: routing.ChatGlobal, From: from, Text: text}
+}
+
+func TestFilter(t *testing.T) {
+	room := NewRoom(Options{MaxLength: 20, Banned: []string{"darn"}})
+	now := time.Now()
+	tests := []struct {
+		text string
+		want string
+		err  bool
+	}{
+		{"  hello  ", "hello", false},
+		{"Darn it, darn!", "**** it, ****!", false},
+		{"darned", "darned", false},
+		{"   ", "", true},
+		{strings.Repeat("é", 21), "", true},
+		{strings.Repeat("é", 20), strings.Repeat("é", 20), false},
+	}
+	for _, tt := range tests {
+		deliveries, err := room.Post(say("alice", tt.text), nil, now)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q got through", tt.text)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.text, err)
+			continue
+		}
+		if got := deliveries[0].Message.Text; got != tt.want {
+			t.Errorf("%q came out as %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	room := NewRoom(Options{Burst: 3, Refill: time.Second, History: 10})
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if _, err := room.Post(say("alice", "hi"), nil, now); err != nil {
+			t.Fatalf("message %d of the burst: %v", i+1, err)
+		}
+	}
+	if _, err := room.Post(say("alice", "hi"), nil, now); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("a fourth message at once: %v", err)
+	}
+	if _, err := room.Post(say("bob", "hi"), nil, now); err != nil {
+		t.Errorf("bob was limited for alice's messages: %v", err)
+	}
+
+	// One message comes back every second, and never more than the burst.
+	if _, err := room.Post(say("alice", "hi"), nil, now.Add(time.Second)); err != nil {
+		t.Errorf("no message a second later: %v", err)
+	}
+	if _, err := room.History("alice", now.Add(time.Second)); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("asking for history went around the limit: %v", err)
+	}
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if _, err := room.History("alice", later); err != nil {
+			t.Fatalf("history request %d after an hour: %v", i+1, err)
+		}
+	}
+	if _, err := room.Post(say("alice", "hi"), nil, later); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("the bucket refilled past its burst: %v", err)
+	}
+}
+
+func TestDeliveriesAndHistory(t *testing.T) {
+	room := NewRoom(Options{History: 10})
+	now := time.Now()
+
+	deliveries, err := room.Post(routing.ChatMessage{Channel: routing.ChatAlliance, From: "alice", Text: "attack"}, []string{"bob"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, d := range deliveries {
+		keys = append(keys, d.Key)
+	}
+	if strings.Join(keys, " ") != "chat.alliance.alice chat.alliance.bob" {
+		t.Errorf("alliance message went to %v", keys)
+	}
+	if _, err := room.Post(routing.ChatMessage{Channel: routing.ChatAlliance, From: "carol", Text: "anyone?"}, nil, now); err == nil {
+		t.Error("carol talked to allies without having any")
+	}
+	if _, err := room.Post(routing.ChatMessage{Channel: routing.ChatDirect, From: "alice", To: "bob", Text: "psst"}, nil, now); err != nil {
+		t.Fatal(err)
+	}
+
+	for username, want := range map[string]int{"alice": 2, "bob": 2, "carol": 0} {
+		history, err := room.History(username, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != want {
+			t.Errorf("%s sees %d messages, want %d", username, len(history), want)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	msg := say("alice", "hi")
+	tests := []struct {
+		origin pubsub.Origin
+		ok     bool
+	}{
+		{pubsub.Origin{Key: routing.ChatKey(routing.ChatSend, "alice"), UserID: "alice"}, true},
+		{pubsub.Origin{Key: routing.ChatKey(routing.ChatSend, "alice"), UserID: "bob"}, false},
+		{pubsub.Origin{Key: routing.ChatKey(routing.ChatSend, "bob"), UserID: "alice"}, false},
+	}
+	for _, tt := range tests {
+		if err := Verify(tt.origin, msg); (err == nil) != tt.ok {
+			t.Errorf("%+v: %v", tt.origin, err)
+		}
+	}
+}
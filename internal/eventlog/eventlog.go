@@ -0,0 +1,228 @@
+// Package eventlog records what happens in a game as typed events in an
+// append-only JSON lines file, so a game can be replayed afterwards.
+package eventlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// DefaultPath is where the server records events.
+const DefaultPath = "game_events/events.jsonl"
+
+type Kind string
+
+const (
+	// KindSnapshot replaces all units of a player, like when they join or
+	// the server loads a saved world.
+	KindSnapshot    Kind = "snapshot"
+	KindSpawned     Kind = "spawned"
+	KindMoved       Kind = "moved"
+	KindWarDeclared Kind = "war_declared"
+	KindWarResult   Kind = "war_result"
+	KindDestroyed   Kind = "destroyed"
+	KindEliminated  Kind = "eliminated"
+	KindCaptured    Kind = "captured"
+	KindFortified   Kind = "fortified"
+	KindIncome      Kind = "income"
+	KindPaused      Kind = "paused"
+	KindResumed     Kind = "resumed"
+)
+
+// Event is one thing that happened in the game. Seq is given by the log and
+// increases by one for every event, DeltaSeq is the state delta it came from.
+type Event struct {
+	Seq      uint64               `json:"seq"`
+	Time     time.Time            `json:"time"`
+	Kind     Kind                 `json:"kind"`
+	Username string               `json:"username,omitempty"`
+	Units    []gamelogic.Unit     `json:"units,omitempty"`
+	Location gamelogic.Location   `json:"location,omitempty"`
+	War      *gamelogic.WarResult `json:"war,omitempty"`
+	// Territory is how a territory looks after a capture or fortification,
+	// Territories every controlled territory on snapshots.
+	Territory   *gamelogic.Territory  `json:"territory,omitempty"`
+	Territories []gamelogic.Territory `json:"territories,omitempty"`
+	// Gold is how much the player's treasury changed by and Balance what
+	// is in it afterwards, on snapshots, spawns, fortifications, income
+	// and eliminations.
+	Gold     int    `json:"gold,omitempty"`
+	Balance  int    `json:"balance,omitempty"`
+	DeltaSeq uint64 `json:"delta_seq,omitempty"`
+}
+
+func (e Event) String() string {
+	prefix := fmt.Sprintf("#%d %s", e.Seq, e.Time.Format(time.RFC3339))
+	switch e.Kind {
+	case KindSnapshot:
+		return fmt.Sprintf("%s %s has %d units", prefix, e.Username, len(e.Units))
+	case KindSpawned:
+		return fmt.Sprintf("%s %s spawned %d unit(s) in %s", prefix, e.Username, len(e.Units), e.Location)
+	case KindMoved:
+		return fmt.Sprintf("%s %s moved %d unit(s) to %s", prefix, e.Username, len(e.Units), e.Location)
+	case KindWarDeclared:
+		return fmt.Sprintf("%s %s declared war on %s in %s", prefix, e.War.Attacker, e.War.Defender, e.Location)
+	case KindWarResult:
+		if e.War.Winner == "" {
+			return fmt.Sprintf("%s the war between %s and %s in %s was a draw", prefix, e.War.Attacker, e.War.Defender, e.Location)
+		}
+		return fmt.Sprintf("%s %s won the war against %s in %s (%d-%d units lost)", prefix, e.War.Winner, e.War.Loser, e.Location, len(e.War.AttackerLosses), len(e.War.DefenderLosses))
+	case KindDestroyed:
+		return fmt.Sprintf("%s %s lost %d unit(s) in %s", prefix, e.Username, len(e.Units), e.Location)
+	case KindEliminated:
+		return fmt.Sprintf("%s %s was eliminated", prefix, e.Username)
+	case KindCaptured:
+		return fmt.Sprintf("%s %s took control of %s", prefix, e.Username, e.Location)
+	case KindFortified:
+		return fmt.Sprintf("%s %s fortified %s", prefix, e.Username, e.Location)
+	case KindIncome:
+		return fmt.Sprintf("%s %s earned %d gold, %d in total", prefix, e.Username, e.Gold, e.Balance)
+	case KindPaused:
+		return prefix + " the game was paused"
+	case KindResumed:
+		return prefix + " the game was resumed"
+	}
+	return fmt.Sprintf("%s %s", prefix, e.Kind)
+}
+
+// FromDelta turns a state delta into an event. Rejected intents didn't
+// change anything and aren't events.
+func FromDelta(delta gamelogic.StateDelta) (Event, bool) {
+	kinds := map[gamelogic.DeltaKind]Kind{
+		gamelogic.DeltaSnapshot:    KindSnapshot,
+		gamelogic.DeltaSpawned:     KindSpawned,
+		gamelogic.DeltaMoved:       KindMoved,
+		gamelogic.DeltaWarDeclared: KindWarDeclared,
+		gamelogic.DeltaWar:         KindWarResult,
+		gamelogic.DeltaDestroyed:   KindDestroyed,
+		gamelogic.DeltaEliminated:  KindEliminated,
+		gamelogic.DeltaCaptured:    KindCaptured,
+		gamelogic.DeltaFortified:   KindFortified,
+		gamelogic.DeltaIncome:      KindIncome,
+	}
+	kind, ok := kinds[delta.Kind]
+	if !ok {
+		return Event{}, false
+	}
+	return Event{
+		Kind:        kind,
+		Username:    delta.Username,
+		Units:       delta.Units,
+		Location:    delta.Location,
+		War:         delta.War,
+		Territory:   delta.Territory,
+		Territories: delta.Territories,
+		Gold:        delta.Gold,
+		Balance:     delta.Balance,
+		DeltaSeq:    delta.Seq,
+	}, true
+}
+
+func FromPlayingState(ps routing.PlayingState) Event {
+	if ps.IsPaused {
+		return Event{Kind: KindPaused}
+	}
+	return Event{Kind: KindResumed}
+}
+
+// Log appends events to a file and syncs it before Append returns. Events
+// are never changed or removed once written.
+type Log struct {
+	mu   sync.Mutex
+	file *os.File
+	seq  uint64
+}
+
+// Open opens the log at path, creating it if needed. Numbering carries on
+// from the last event already in it.
+func Open(path string) (*Log, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("could not create event log directory: %v", err)
+	}
+	events, err := ReadAll(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open event log: %v", err)
+	}
+
+	l := &Log{file: f}
+	if len(events) > 0 {
+		l.seq = events[len(events)-1].Seq
+	}
+	return l, nil
+}
+
+// Append numbers the events, stamps those without a time and writes them.
+func (l *Log) Append(events ...Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	buf := []byte{}
+	seq := l.seq
+	for _, e := range events {
+		seq++
+		e.Seq = seq
+		if e.Time.IsZero() {
+			e.Time = time.Now()
+		}
+		line, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("could not encode event: %v", err)
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+
+	_, err := l.file.Write(buf)
+	if err != nil {
+		return fmt.Errorf("could not write to event log: %v", err)
+	}
+	err = l.file.Sync()
+	if err != nil {
+		return fmt.Errorf("could not sync event log: %v", err)
+	}
+	l.seq = seq
+	return nil
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// ReadAll reads every event in the log in the order they were written. A
+// line cut short by a crash is skipped, a missing log has no events.
+func ReadAll(path string) ([]Event, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	events := []Event{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Event
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, scanner.Err()
+}
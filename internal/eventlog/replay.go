@@ -0,0 +1,169 @@
+package eventlog
+
+import (
+	"sort"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+)
+
+// Replay rebuilds the game from recorded events, one event at a time.
+type Replay struct {
+	events  []Event
+	next    int
+	players map[string]map[int]gamelogic.Unit
+	// territories is who controls what.
+	territories map[gamelogic.Location]gamelogic.Territory
+	balances    map[string]int
+	paused      bool
+}
+
+func NewReplay(events []Event) *Replay {
+	r := &Replay{events: events}
+	r.Reset()
+	return r
+}
+
+// Reset goes back to before the first event.
+func (r *Replay) Reset() {
+	r.next = 0
+	r.players = map[string]map[int]gamelogic.Unit{}
+	r.territories = map[gamelogic.Location]gamelogic.Territory{}
+	r.balances = map[string]int{}
+	r.paused = false
+}
+
+// Step applies the next event and returns it, or false once every event
+// has been applied.
+func (r *Replay) Step() (Event, bool) {
+	if r.next >= len(r.events) {
+		return Event{}, false
+	}
+	e := r.events[r.next]
+	r.next++
+	r.apply(e)
+	return e, true
+}
+
+// SeekTime replays every event up to and including t.
+func (r *Replay) SeekTime(t time.Time) {
+	r.Reset()
+	for r.next < len(r.events) && !r.events[r.next].Time.After(t) {
+		r.Step()
+	}
+}
+
+// SeekSeq replays every event up to and including the one numbered seq.
+func (r *Replay) SeekSeq(seq uint64) {
+	r.Reset()
+	for r.next < len(r.events) && r.events[r.next].Seq <= seq {
+		r.Step()
+	}
+}
+
+// Applied is how many events have been replayed so far.
+func (r *Replay) Applied() int {
+	return r.next
+}
+
+func (r *Replay) Len() int {
+	return len(r.events)
+}
+
+// Last is the most recently applied event.
+func (r *Replay) Last() (Event, bool) {
+	if r.next == 0 {
+		return Event{}, false
+	}
+	return r.events[r.next-1], true
+}
+
+// Usernames is everyone seen so far, sorted.
+func (r *Replay) Usernames() []string {
+	usernames := make([]string, 0, len(r.players))
+	for username := range r.players {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	return usernames
+}
+
+// GameState is what the player's client would have had at this point.
+func (r *Replay) GameState(username string) *gamelogic.GameState {
+	gs := gamelogic.NewGameState(username)
+	units := map[int]gamelogic.Unit{}
+	for id, unit := range r.players[username] {
+		units[id] = unit
+	}
+	territories := []gamelogic.Territory{}
+	for _, territory := range r.territories {
+		territories = append(territories, territory)
+	}
+	gs.Restore(gamelogic.GameStateSnapshot{
+		Player:      gamelogic.Player{Username: username, Units: units},
+		Paused:      r.paused,
+		Balance:     r.balances[username],
+		Territories: territories,
+	})
+	return gs
+}
+
+func (r *Replay) apply(e Event) {
+	switch e.Kind {
+	case KindPaused:
+		r.paused = true
+		return
+	case KindResumed:
+		r.paused = false
+		return
+	case KindCaptured, KindFortified:
+		if e.Territory != nil {
+			r.territories[e.Territory.Location] = *e.Territory
+		}
+	case KindSnapshot:
+		// Snapshots hold every controlled territory, like after the server
+		// loaded a saved world.
+		r.territories = map[gamelogic.Location]gamelogic.Territory{}
+		for _, territory := range e.Territories {
+			r.territories[territory.Location] = territory
+		}
+	}
+	if e.Username == "" {
+		return
+	}
+
+	switch e.Kind {
+	case KindSnapshot, KindSpawned, KindFortified, KindIncome, KindEliminated:
+		r.balances[e.Username] = e.Balance
+	}
+
+	units, ok := r.players[e.Username]
+	if !ok || e.Kind == KindSnapshot {
+		units = map[int]gamelogic.Unit{}
+		r.players[e.Username] = units
+	}
+	switch e.Kind {
+	case KindSnapshot, KindSpawned, KindMoved:
+		for _, unit := range e.Units {
+			units[unit.ID] = unit
+		}
+	case KindDestroyed:
+		for _, unit := range e.Units {
+			delete(units, unit.ID)
+		}
+	case KindWarDeclared, KindWarResult:
+		if e.War == nil {
+			return
+		}
+		if _, ok := r.players[e.War.Defender]; !ok {
+			r.players[e.War.Defender] = map[int]gamelogic.Unit{}
+		}
+	}
+}
+
+// StateAt rebuilds the game state of a player as it was at t.
+func StateAt(events []Event, username string, t time.Time) *gamelogic.GameState {
+	r := NewReplay(events)
+	r.SeekTime(t)
+	return r.GameState(username)
+}
@@ -0,0 +1,170 @@
+package eventlog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func record(t *testing.T, path string, events ...Event) {
+	t.Helper()
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := l.Append(events...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLogNumbersAcrossOpens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "game", "events.jsonl")
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	record(t, path, Event{Kind: KindSpawned, Username: "alice", Time: start}, Event{Kind: KindMoved, Username: "alice"})
+	record(t, path, FromPlayingState(routing.PlayingState{IsPaused: true}))
+
+	// A line cut short by a crash is skipped.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":4,"kind":"mov`)
+	f.Close()
+
+	events, err := ReadAll(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("read %d events, want 3", len(events))
+	}
+	for i, e := range events {
+		if e.Seq != uint64(i+1) {
+			t.Errorf("event %d is numbered %d", i, e.Seq)
+		}
+	}
+	if !events[0].Time.Equal(start) || events[1].Time.IsZero() {
+		t.Errorf("events were stamped %s and %s", events[0].Time, events[1].Time)
+	}
+	if events[2].Kind != KindPaused {
+		t.Errorf("pausing was recorded as %s", events[2].Kind)
+	}
+
+	if events, err := ReadAll(filepath.Join(t.TempDir(), "missing.jsonl")); err != nil || len(events) != 0 {
+		t.Errorf("a missing log has %d events: %v", len(events), err)
+	}
+}
+
+func TestFromDelta(t *testing.T) {
+	if _, ok := FromDelta(gamelogic.StateDelta{Kind: gamelogic.DeltaRejected, Username: "alice"}); ok {
+		t.Error("a rejected intent became an event")
+	}
+	e, ok := FromDelta(gamelogic.StateDelta{Seq: 7, Kind: gamelogic.DeltaIncome, Username: "alice", Gold: 5, Balance: 12})
+	if !ok || e.Kind != KindIncome || e.Gold != 5 || e.Balance != 12 || e.DeltaSeq != 7 {
+		t.Errorf("income became %+v", e)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time {
+		return start.Add(time.Duration(minutes) * time.Minute)
+	}
+	europe := gamelogic.Territory{Location: "europe", Owner: "alice"}
+	fortified := gamelogic.Territory{Location: "europe", Owner: "alice", Forts: 1}
+	asia := gamelogic.Territory{Location: "asia", Owner: "bob"}
+	infantry := gamelogic.Unit{ID: 1, Rank: gamelogic.RankInfantry, Location: "europe"}
+	moved := gamelogic.Unit{ID: 1, Rank: gamelogic.RankInfantry, Location: "asia"}
+	cavalry := gamelogic.Unit{ID: 2, Rank: gamelogic.RankCavalry, Location: "asia"}
+
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	record(t, path,
+		Event{Time: at(0), Kind: KindSnapshot, Username: "alice", Balance: 10, Territories: []gamelogic.Territory{europe}},
+		Event{Time: at(1), Kind: KindSpawned, Username: "alice", Units: []gamelogic.Unit{infantry}, Location: "europe", Balance: 9},
+		Event{Time: at(2), Kind: KindCaptured, Username: "bob", Location: "asia", Territory: &asia},
+		Event{Time: at(2), Kind: KindSpawned, Username: "bob", Units: []gamelogic.Unit{cavalry}, Location: "asia", Balance: 6},
+		Event{Time: at(3), Kind: KindFortified, Username: "alice", Location: "europe", Territory: &fortified, Balance: 4},
+		Event{Time: at(4), Kind: KindPaused},
+		Event{Time: at(5), Kind: KindResumed},
+		Event{Time: at(6), Kind: KindMoved, Username: "alice", Units: []gamelogic.Unit{moved}, Location: "asia"},
+		Event{Time: at(7), Kind: KindDestroyed, Username: "bob", Units: []gamelogic.Unit{cavalry}, Location: "asia"},
+		Event{Time: at(8), Kind: KindIncome, Username: "alice", Gold: 2, Balance: 6},
+	)
+	events, err := ReadAll(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReplay(events)
+	if _, ok := r.Last(); ok {
+		t.Error("a fresh replay has a last event")
+	}
+	for i := 0; i < 3; i++ {
+		r.Step()
+	}
+	if last, _ := r.Last(); r.Applied() != 3 || last.Kind != KindCaptured {
+		t.Errorf("after three steps %d events were applied, the last a %s", r.Applied(), last.Kind)
+	}
+	for {
+		if _, ok := r.Step(); !ok {
+			break
+		}
+	}
+	if r.Applied() != r.Len() {
+		t.Errorf("stepped through %d of %d events", r.Applied(), r.Len())
+	}
+	if got := r.Usernames(); len(got) != 2 || got[0] != "alice" || got[1] != "bob" {
+		t.Errorf("usernames: %v", got)
+	}
+
+	snap := r.GameState("alice").Snapshot()
+	if unit, ok := snap.Player.Units[1]; len(snap.Player.Units) != 1 || !ok || unit != moved {
+		t.Errorf("alice ends with %+v", snap.Player.Units)
+	}
+	if snap.Balance != 6 {
+		t.Errorf("alice ends with %d gold, want 6", snap.Balance)
+	}
+	if len(r.GameState("bob").GetPlayerSnap().Units) != 0 {
+		t.Error("bob's destroyed cavalry is still around")
+	}
+	territories := map[gamelogic.Location]gamelogic.Territory{}
+	for _, territory := range snap.Territories {
+		territories[territory.Location] = territory
+	}
+	if territories["europe"] != fortified || territories["asia"] != asia {
+		t.Errorf("territories: %+v", territories)
+	}
+
+	// Seeking by time takes every event up to and including it.
+	r.SeekTime(at(2))
+	if r.Applied() != 4 {
+		t.Errorf("seeking to minute 2 applied %d events, want 4", r.Applied())
+	}
+	if balance := r.GameState("bob").GetBalance(); balance != 6 {
+		t.Errorf("bob has %d gold at minute 2, want 6", balance)
+	}
+	if snap := StateAt(events, "alice", at(4)).Snapshot(); !snap.Paused || snap.Balance != 4 {
+		t.Errorf("at minute 4 alice has %d gold and paused is %v", snap.Balance, snap.Paused)
+	}
+	if StateAt(events, "alice", at(5)).Snapshot().Paused {
+		t.Error("still paused after resuming")
+	}
+
+	r.SeekSeq(2)
+	if unit, ok := r.GameState("alice").GetUnit(1); !ok || unit != infantry {
+		t.Errorf("after event 2 alice's unit is %+v", unit)
+	}
+	if got := r.Usernames(); len(got) != 1 {
+		t.Errorf("bob shows up before the first event about bob: %v", got)
+	}
+	r.Reset()
+	if r.Applied() != 0 || len(r.Usernames()) != 0 {
+		t.Error("reset kept some of the replay")
+	}
+}
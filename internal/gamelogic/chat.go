@@ -0,0 +1,85 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// SayMessage turns a say command into a chat message for everyone, or only
+// for the player's allies with -a.
+func (gs *GameState) SayMessage(words []string) (routing.ChatMessage, error) {
+	channel := routing.ChatGlobal
+	words = words[1:]
+	if len(words) > 0 && words[0] == "-a" {
+		channel = routing.ChatAlliance
+		words = words[1:]
+	}
+	if len(words) == 0 {
+		return routing.ChatMessage{}, errors.New("usage: say [-a] <message>")
+	}
+	return routing.ChatMessage{
+		Channel: channel,
+		From:    gs.GetUsername(),
+		Text:    strings.Join(words, " "),
+		SentAt:  time.Now(),
+	}, nil
+}
+
+// WhisperMessage turns a whisper command into a chat message only the other
+// player sees.
+func (gs *GameState) WhisperMessage(words []string) (routing.ChatMessage, error) {
+	if len(words) < 3 {
+		return routing.ChatMessage{}, errors.New("usage: whisper <username> <message>")
+	}
+	if words[1] == gs.GetUsername() {
+		return routing.ChatMessage{}, errors.New("error: you can't whisper to yourself")
+	}
+	return routing.ChatMessage{
+		Channel: routing.ChatDirect,
+		From:    gs.GetUsername(),
+		To:      words[1],
+		Text:    strings.Join(words[2:], " "),
+		SentAt:  time.Now(),
+	}, nil
+}
+
+// HandleChat shows a chat message, or every message in a history reply.
+func (gs *GameState) HandleChat(msg routing.ChatMessage) {
+	if msg.Channel != routing.ChatHistory {
+		fmt.Println()
+		gs.printChat(msg)
+		return
+	}
+	fmt.Println()
+	if len(msg.History) == 0 {
+		fmt.Println("Nobody has said anything yet.")
+		return
+	}
+	fmt.Println("==== Chat History ====")
+	for _, old := range msg.History {
+		gs.printChat(old)
+	}
+	fmt.Println("------------------------")
+}
+
+func (gs *GameState) printChat(msg routing.ChatMessage) {
+	at := msg.SentAt.Format(time.TimeOnly)
+	switch msg.Channel {
+	case routing.ChatGlobal:
+		fmt.Printf("[%s] %s: %s\n", at, msg.From, msg.Text)
+	case routing.ChatAlliance:
+		fmt.Printf("[%s] (allies) %s: %s\n", at, msg.From, msg.Text)
+	case routing.ChatDirect:
+		if msg.From == gs.GetUsername() {
+			fmt.Printf("[%s] you -> %s: %s\n", at, msg.To, msg.Text)
+		} else {
+			fmt.Printf("[%s] %s -> you: %s\n", at, msg.From, msg.Text)
+		}
+	case routing.ChatSystem:
+		fmt.Printf("[%s] %s\n", at, msg.Text)
+	}
+}
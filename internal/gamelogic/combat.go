@@ -0,0 +1,223 @@
+package gamelogic
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// CombatResolver decides a war between the units two players have in one
+// location, and which of them die. The defender's power is multiplied by
+// defense, 1 when they don't hold the location.
+type CombatResolver interface {
+	Resolve(attacker, defender Player, location Location, defense float64) WarResult
+}
+
+// Counters is which rank each rank has the upper hand against: cavalry
+// rides down artillery in the open, artillery shells infantry and infantry
+// holds off cavalry.
+var Counters = map[UnitRank]UnitRank{
+	RankCavalry:   RankArtillery,
+	RankArtillery: RankInfantry,
+	RankInfantry:  RankCavalry,
+}
+
+// Combat is every resolver the server can be started with, by name.
+var Combat = map[string]func(seed int64) CombatResolver{
+	"classic":      func(int64) CombatResolver { return ClassicResolver{} },
+	"proportional": func(int64) CombatResolver { return ProportionalResolver{} },
+	"dice":         func(seed int64) CombatResolver { return NewDiceResolver(seed) },
+}
+
+// ClassicResolver is the rule Peril started with: the side with more power
+// wins and the loser loses every unit there, on a draw both sides do.
+type ClassicResolver struct{}
+
+func (ClassicResolver) Resolve(attacker, defender Player, location Location, defense float64) WarResult {
+	attackerUnits, defenderUnits := UnitsIn(attacker, location), UnitsIn(defender, location)
+	result := newWarResult(attacker, defender, location, unitsToPowerLevel(attackerUnits), applyDefense(unitsToPowerLevel(defenderUnits), defense))
+	switch result.Winner {
+	case attacker.Username:
+		result.DefenderLosses = defenderUnits
+	case defender.Username:
+		result.AttackerLosses = attackerUnits
+	default:
+		result.AttackerLosses, result.DefenderLosses = attackerUnits, defenderUnits
+	}
+	return result
+}
+
+// ProportionalResolver counts rank counters and only kills part of a stack.
+// The loser loses the share of their units that the power difference is of
+// their power, the winner half the share the loser's power is of theirs. On
+// a draw both sides lose half. The weakest units die first.
+type ProportionalResolver struct{}
+
+func (ProportionalResolver) Resolve(attacker, defender Player, location Location, defense float64) WarResult {
+	attackerUnits, defenderUnits := UnitsIn(attacker, location), UnitsIn(defender, location)
+	result := newWarResult(attacker, defender, location, counteredPower(attackerUnits, defenderUnits), applyDefense(counteredPower(defenderUnits, attackerUnits), defense))
+
+	switch result.Winner {
+	case "":
+		result.AttackerLosses = weakest(attackerUnits, (len(attackerUnits)+1)/2)
+		result.DefenderLosses = weakest(defenderUnits, (len(defenderUnits)+1)/2)
+	default:
+		winnerUnits, loserUnits := attackerUnits, defenderUnits
+		winnerPower, loserPower := result.AttackerPower, result.DefenderPower
+		if result.Winner == defender.Username {
+			winnerUnits, loserUnits = defenderUnits, attackerUnits
+			winnerPower, loserPower = loserPower, winnerPower
+		}
+		// Rounded up so the loser always loses at least one unit.
+		loserLosses := len(loserUnits)
+		if loserPower > 0 {
+			loserLosses = (len(loserUnits)*(winnerPower-loserPower) + loserPower - 1) / loserPower
+		}
+		winnerLosses := len(winnerUnits) * loserPower / (2 * winnerPower)
+		if result.Winner == attacker.Username {
+			result.AttackerLosses, result.DefenderLosses = weakest(winnerUnits, winnerLosses), weakest(loserUnits, loserLosses)
+		} else {
+			result.AttackerLosses, result.DefenderLosses = weakest(loserUnits, loserLosses), weakest(winnerUnits, winnerLosses)
+		}
+	}
+	return result
+}
+
+// NewDiceResolver fights like Risk: every round the attacker's three
+// strongest units each roll a die against the defender's two strongest,
+// highest rolls paired up. Each unit adds its power to its roll, plus 2 if
+// it counters the unit it is paired with, and ties go to the defender. A
+// defender holding the location has their power, not the die, multiplied by
+// its defense. The loser of each pair loses their weakest unit. Rounds go on
+// until one side is wiped out.
+//
+// Rolls come from a source seeded with seed, so the same seed replays the
+// same wars.
+func NewDiceResolver(seed int64) CombatResolver {
+	return &diceResolver{rng: rand.New(rand.NewSource(seed))}
+}
+
+type diceResolver struct {
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func (r *diceResolver) Resolve(attacker, defender Player, location Location, defense float64) WarResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attackerUnits, defenderUnits := UnitsIn(attacker, location), UnitsIn(defender, location)
+	result := newWarResult(attacker, defender, location, unitsToPowerLevel(attackerUnits), applyDefense(unitsToPowerLevel(defenderUnits), defense))
+	result.Winner, result.Loser = "", ""
+
+	attackers, defenders := strongestFirst(attackerUnits), strongestFirst(defenderUnits)
+	for len(attackers) > 0 && len(defenders) > 0 {
+		attackRolls := r.roll(attackers[:min(3, len(attackers))], 1)
+		defendRolls := r.roll(defenders[:min(2, len(defenders))], defense)
+		for i := 0; i < min(len(attackRolls), len(defendRolls)); i++ {
+			attack := attackRolls[i].value + counterBonus(attackRolls[i].unit, defendRolls[i].unit)
+			defend := defendRolls[i].value + counterBonus(defendRolls[i].unit, attackRolls[i].unit)
+			if attack > defend {
+				result.DefenderLosses = append(result.DefenderLosses, defenders[len(defenders)-1])
+				defenders = defenders[:len(defenders)-1]
+			} else {
+				result.AttackerLosses = append(result.AttackerLosses, attackers[len(attackers)-1])
+				attackers = attackers[:len(attackers)-1]
+			}
+		}
+	}
+	if len(attackers) > 0 {
+		result.Winner, result.Loser = attacker.Username, defender.Username
+	} else {
+		result.Winner, result.Loser = defender.Username, attacker.Username
+	}
+	return result
+}
+
+type roll struct {
+	unit  Unit
+	value int
+}
+
+// roll rolls a die for every unit, highest roll first.
+func (r *diceResolver) roll(units []Unit, defense float64) []roll {
+	rolls := make([]roll, 0, len(units))
+	for _, unit := range units {
+		rolls = append(rolls, roll{unit: unit, value: r.rng.Intn(6) + 1 + applyDefense(unitsToPowerLevel([]Unit{unit}), defense)})
+	}
+	sort.SliceStable(rolls, func(i, j int) bool { return rolls[i].value > rolls[j].value })
+	return rolls
+}
+
+func counterBonus(unit, against Unit) int {
+	if Counters[unit.Rank] == against.Rank {
+		return 2
+	}
+	return 0
+}
+
+func newWarResult(attacker, defender Player, location Location, attackerPower, defenderPower int) WarResult {
+	result := WarResult{
+		Attacker:      attacker.Username,
+		Defender:      defender.Username,
+		Location:      location,
+		AttackerPower: attackerPower,
+		DefenderPower: defenderPower,
+	}
+	if attackerPower > defenderPower {
+		result.Winner, result.Loser = attacker.Username, defender.Username
+	} else if defenderPower > attackerPower {
+		result.Winner, result.Loser = defender.Username, attacker.Username
+	}
+	return result
+}
+
+// counteredPower is the power of units against enemies, where every unit
+// whose rank counters one of the enemy ranks fights at one and a half times
+// its power.
+func counteredPower(units, enemies []Unit) int {
+	enemyRanks := map[UnitRank]bool{}
+	for _, enemy := range enemies {
+		enemyRanks[enemy.Rank] = true
+	}
+	halves := 0
+	for _, unit := range units {
+		power := unitsToPowerLevel([]Unit{unit})
+		if enemyRanks[Counters[unit.Rank]] {
+			halves += 3 * power
+		} else {
+			halves += 2 * power
+		}
+	}
+	return halves / 2
+}
+
+// strongestFirst sorts units by power, ties by ID.
+func strongestFirst(units []Unit) []Unit {
+	sorted := append([]Unit{}, units...)
+	sort.Slice(sorted, func(i, j int) bool {
+		pi, pj := unitsToPowerLevel(sorted[i:i+1]), unitsToPowerLevel(sorted[j:j+1])
+		if pi != pj {
+			return pi > pj
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+	return sorted
+}
+
+// weakest picks the n weakest units.
+func weakest(units []Unit, n int) []Unit {
+	sorted := strongestFirst(units)
+	n = max(0, min(n, len(sorted)))
+	return sorted[len(sorted)-n:]
+}
+
+// ParseCombat looks up a resolver by name.
+func ParseCombat(name string, seed int64) (CombatResolver, error) {
+	newResolver, ok := Combat[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown combat mode '%s', use classic, proportional or dice", name)
+	}
+	return newResolver(seed), nil
+}
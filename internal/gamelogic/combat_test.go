@@ -0,0 +1,81 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func army(username string, location Location, ranks ...UnitRank) Player {
+	player := Player{Username: username, Units: map[int]Unit{}}
+	for i, rank := range ranks {
+		player.Units[i+1] = Unit{ID: i + 1, Rank: rank, Location: location}
+	}
+	return player
+}
+
+func TestDiceResolverReplaysWithSeed(t *testing.T) {
+	attacker := army("alice", "europe", RankInfantry, RankInfantry, RankCavalry, RankArtillery, RankInfantry)
+	defender := army("bob", "europe", RankInfantry, RankArtillery, RankCavalry, RankInfantry)
+
+	fight := func(seed int64) []WarResult {
+		resolver := NewDiceResolver(seed)
+		results := []WarResult{}
+		for i := 0; i < 10; i++ {
+			results = append(results, resolver.Resolve(attacker, defender, "europe", 1.5))
+		}
+		return results
+	}
+
+	first, again := fight(42), fight(42)
+	if !reflect.DeepEqual(first, again) {
+		t.Fatal("the same seed fought different wars")
+	}
+	if reflect.DeepEqual(first, fight(7)) {
+		t.Error("ten wars came out exactly the same with another seed")
+	}
+
+	for i, result := range first {
+		switch result.Winner {
+		case "alice":
+			if len(result.DefenderLosses) != len(defender.Units) || len(result.AttackerLosses) >= len(attacker.Units) || result.Loser != "bob" {
+				t.Errorf("war %d: alice won losing %d of %d units while bob lost %d of %d", i, len(result.AttackerLosses), len(attacker.Units), len(result.DefenderLosses), len(defender.Units))
+			}
+		case "bob":
+			if len(result.AttackerLosses) != len(attacker.Units) || len(result.DefenderLosses) >= len(defender.Units) || result.Loser != "alice" {
+				t.Errorf("war %d: bob won losing %d of %d units while alice lost %d of %d", i, len(result.DefenderLosses), len(defender.Units), len(result.AttackerLosses), len(attacker.Units))
+			}
+		default:
+			t.Errorf("war %d ended in a draw, dice wars go on until one side is gone", i)
+		}
+	}
+}
+
+func TestDiceResolverLosesWeakestFirst(t *testing.T) {
+	attacker := army("alice", "asia", RankArtillery, RankInfantry, RankInfantry)
+	defender := army("bob", "asia", RankArtillery, RankArtillery)
+
+	for seed := int64(0); seed < 20; seed++ {
+		result := NewDiceResolver(seed).Resolve(attacker, defender, "asia", 1)
+		for i, lost := range result.AttackerLosses {
+			if i < 2 && lost.Rank != RankInfantry {
+				t.Errorf("seed %d: alice lost the %s before the infantry", seed, lost.Rank)
+			}
+		}
+	}
+}
+
+func TestClassicResolver(t *testing.T) {
+	attacker := army("alice", "africa", RankArtillery)
+	defender := army("bob", "africa", RankInfantry, RankInfantry)
+
+	result := ClassicResolver{}.Resolve(attacker, defender, "africa", 1)
+	if result.Winner != "alice" || len(result.DefenderLosses) != 2 || len(result.AttackerLosses) != 0 {
+		t.Errorf("artillery against two infantry: %q won, losses %d and %d", result.Winner, len(result.AttackerLosses), len(result.DefenderLosses))
+	}
+
+	// Holding the location is worth enough for the defender here.
+	result = ClassicResolver{}.Resolve(army("alice", "africa", RankCavalry), army("bob", "africa", RankInfantry, RankInfantry, RankInfantry, RankInfantry), "africa", 2)
+	if result.Winner != "bob" || len(result.AttackerLosses) != 1 {
+		t.Errorf("cavalry against four defending infantry: %q won", result.Winner)
+	}
+}
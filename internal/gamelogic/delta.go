@@ -0,0 +1,113 @@
+package gamelogic
+
+import "fmt"
+
+// ApplyDelta brings the game state in line with the server. Only deltas
+// about this player change anything, the rest are just reported.
+func (gs *GameState) ApplyDelta(delta StateDelta) {
+	mine := delta.Username == gs.GetUsername()
+
+	switch delta.Kind {
+	case DeltaSnapshot:
+		if !mine {
+			return
+		}
+		gs.replaceUnits(delta.Units)
+		gs.setBalance(delta.Balance)
+		gs.replaceTerritories(delta.Territories)
+		fmt.Printf("The server says you have %d units and %d gold.\n", len(delta.Units), delta.Balance)
+	case DeltaSpawned:
+		for _, unit := range delta.Units {
+			if mine {
+				gs.addUnit(unit)
+				gs.setBalance(delta.Balance)
+				fmt.Printf("Spawned a(n) %s in %s with id %v for %d gold, %d left\n", unit.Rank, unit.Location, unit.ID, -delta.Gold, delta.Balance)
+			} else {
+				fmt.Printf("%s spawned a(n) %s in %s\n", delta.Username, unit.Rank, unit.Location)
+			}
+		}
+	case DeltaMoved:
+		if mine {
+			for _, unit := range delta.Units {
+				gs.UpdateUnit(unit)
+			}
+			fmt.Printf("Moved %v units to %s\n", len(delta.Units), delta.Location)
+			return
+		}
+		fmt.Println()
+		fmt.Println("==== Move Detected ====")
+		fmt.Printf("%s is moving %v unit(s) to %s\n", delta.Username, len(delta.Units), delta.Location)
+		for _, unit := range delta.Units {
+			fmt.Printf("* %v\n", unit.Rank)
+		}
+		fmt.Println("------------------------")
+	case DeltaDestroyed:
+		if mine {
+			gs.removeUnits(delta.Units)
+			fmt.Printf("You lost %d unit(s) in %s.\n", len(delta.Units), delta.Location)
+		}
+	case DeltaWarDeclared:
+		fmt.Println()
+		fmt.Println("==== War Declared ====")
+		fmt.Printf("%s has declared war on %s in %s!\n", delta.War.Attacker, delta.War.Defender, delta.War.Location)
+	case DeltaWar:
+		printWarResult(*delta.War, gs.GetUsername())
+	case DeltaIncome:
+		if mine {
+			gs.setBalance(delta.Balance)
+			fmt.Printf("Your territories paid %d gold, you have %d now.\n", delta.Gold, delta.Balance)
+		}
+	case DeltaEliminated:
+		if mine {
+			fmt.Println("You have been eliminated! You have no units left and can't afford any more.")
+		} else {
+			fmt.Printf("%s has been eliminated.\n", delta.Username)
+		}
+	case DeltaCaptured:
+		previous := gs.GetTerritory(delta.Location).Owner
+		gs.setTerritory(*delta.Territory)
+		switch {
+		case mine && previous == "":
+			fmt.Printf("You have taken control of %s.\n", delta.Location)
+		case mine:
+			fmt.Printf("You have taken %s from %s.\n", delta.Location, previous)
+		case previous == gs.GetUsername():
+			fmt.Printf("%s has taken %s from you!\n", delta.Username, delta.Location)
+		default:
+			fmt.Printf("%s has taken control of %s.\n", delta.Username, delta.Location)
+		}
+	case DeltaFortified:
+		gs.setTerritory(*delta.Territory)
+		if mine {
+			gs.setBalance(delta.Balance)
+			fmt.Printf("Built a fortification in %s for %d gold, %d left. It has %d now.\n", delta.Location, -delta.Gold, delta.Balance, delta.Territory.Forts)
+		} else {
+			fmt.Printf("%s fortified %s.\n", delta.Username, delta.Location)
+		}
+	case DeltaOrdered:
+		if mine {
+			fmt.Printf("Order accepted, it is carried out when turn %d ends.\n", delta.Turn)
+		}
+	case DeltaRejected:
+		if mine {
+			fmt.Printf("The server refused that: %s\n", delta.Reason)
+		}
+	}
+}
+
+func printWarResult(war WarResult, username string) {
+	fmt.Printf("Attacker has a power level of %v\n", war.AttackerPower)
+	fmt.Printf("Defender has a power level of %v\n", war.DefenderPower)
+	fmt.Printf("Attacker lost %d unit(s), defender lost %d.\n", len(war.AttackerLosses), len(war.DefenderLosses))
+	switch {
+	case war.Winner == "":
+		fmt.Println("The war ended in a draw!")
+	case war.Winner == username:
+		fmt.Println("You have won the war!")
+	case war.Loser == username:
+		fmt.Println("You have lost the war!")
+	default:
+		fmt.Printf("%s has won the war!\n", war.Winner)
+	}
+	fmt.Println("------------------------")
+}
@@ -0,0 +1,387 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// DefaultTruce is how long a truce lasts unless its proposal says otherwise.
+const DefaultTruce = 5 * time.Minute
+
+const (
+	// ProposalTTL is how long a proposal can be accepted for.
+	ProposalTTL = 10 * time.Minute
+	// MaxProposals is how many unanswered proposals a player can have out
+	// at once. Proposing more drops their oldest.
+	MaxProposals = 10
+)
+
+// Pact is an agreement between two players. Until is zero for pacts that
+// last until one of them breaks it.
+type Pact struct {
+	Kind    routing.PactKind
+	Players [2]string
+	Since   time.Time
+	Until   time.Time
+}
+
+// Other is the player in the pact who isn't username.
+func (p Pact) Other(username string) string {
+	if p.Players[0] == username {
+		return p.Players[1]
+	}
+	return p.Players[0]
+}
+
+func (p Pact) activeAt(now time.Time) bool {
+	return p.Until.IsZero() || now.Before(p.Until)
+}
+
+func (p Pact) String() string {
+	if p.Until.IsZero() {
+		return fmt.Sprintf("%s between %s and %s", p.Kind, p.Players[0], p.Players[1])
+	}
+	return fmt.Sprintf("%s between %s and %s until %s", p.Kind, p.Players[0], p.Players[1], p.Until.Format(time.TimeOnly))
+}
+
+// Pacts keeps track of the pacts between players and the proposals nobody
+// has answered yet. The server and every client keep their own from the
+// diplomatic messages they see.
+type Pacts struct {
+	mu    *sync.Mutex
+	pacts map[[2]string]Pact
+	// proposals are keyed by who sent them and their ID, so nobody can
+	// replace someone else's proposal by reusing its ID.
+	proposals map[[2]string]routing.Diplomacy
+}
+
+func NewPacts() *Pacts {
+	return &Pacts{mu: &sync.Mutex{}, pacts: map[[2]string]Pact{}, proposals: map[[2]string]routing.Diplomacy{}}
+}
+
+func pair(a, b string) [2]string {
+	if b < a {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
+
+func ValidatePact(kind routing.PactKind) error {
+	switch kind {
+	case routing.PactAlliance, routing.PactTruce, routing.PactNonAggression:
+		return nil
+	}
+	return fmt.Errorf("error: %s is not a pact, use alliance, truce or non_aggression", kind)
+}
+
+// VerifyDiplomacy refuses messages a player sends in someone else's name,
+// or that aren't going where they say. The sender has to be the RabbitMQ
+// user that published it.
+func VerifyDiplomacy(origin pubsub.Origin, msg routing.Diplomacy) error {
+	err := ValidateUsername(msg.To)
+	if err != nil {
+		return err
+	}
+	if origin.Key != routing.DiplomacyKey(msg.To, msg.From) {
+		return fmt.Errorf("message from %s to %s sent with key '%s'", msg.From, msg.To, origin.Key)
+	}
+	if origin.UserID != msg.From {
+		return fmt.Errorf("message from %s sent by user '%s'", msg.From, origin.UserID)
+	}
+	return nil
+}
+
+// Apply updates the pacts with a diplomatic message received at now. Pacts
+// and proposals go by when the message arrived, not the time its sender
+// put on it. It returns the pact a message made or broke, and false if the
+// message didn't change any.
+func (p *Pacts) Apply(msg routing.Diplomacy, now time.Time) (Pact, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expire(now)
+	msg.SentAt = now
+
+	switch msg.Action {
+	case routing.DiplomacyPropose:
+		if ValidatePact(msg.Pact) == nil && msg.From != msg.To {
+			p.limit(msg.From)
+			p.proposals[[2]string{msg.From, msg.ID}] = msg
+		}
+	case routing.DiplomacyAccept:
+		proposal, ok := p.proposals[[2]string{msg.To, msg.ID}]
+		if !ok || proposal.To != msg.From {
+			return Pact{}, false
+		}
+		p.forget(msg.From, msg.To)
+		pact := Pact{Kind: proposal.Pact, Players: pair(msg.From, msg.To), Since: now}
+		if pact.Kind == routing.PactTruce {
+			duration := proposal.Duration
+			if duration <= 0 {
+				duration = DefaultTruce
+			}
+			pact.Until = now.Add(duration)
+		}
+		p.pacts[pact.Players] = pact
+		return pact, true
+	case routing.DiplomacyReject:
+		key := [2]string{msg.To, msg.ID}
+		proposal, ok := p.proposals[key]
+		if ok && proposal.To == msg.From {
+			delete(p.proposals, key)
+		}
+	case routing.DiplomacyBreak:
+		pact, ok := p.pacts[pair(msg.From, msg.To)]
+		if !ok {
+			return Pact{}, false
+		}
+		delete(p.pacts, pact.Players)
+		return pact, true
+	}
+	return Pact{}, false
+}
+
+// expire drops proposals older than ProposalTTL.
+func (p *Pacts) expire(now time.Time) {
+	for key, proposal := range p.proposals {
+		if now.Sub(proposal.SentAt) > ProposalTTL {
+			delete(p.proposals, key)
+		}
+	}
+}
+
+// limit drops a player's oldest proposals until they have room for one
+// more.
+func (p *Pacts) limit(from string) {
+	for {
+		var oldest [2]string
+		count := 0
+		for key, proposal := range p.proposals {
+			if proposal.From != from {
+				continue
+			}
+			if count == 0 || proposal.SentAt.Before(p.proposals[oldest].SentAt) {
+				oldest = key
+			}
+			count++
+		}
+		if count < MaxProposals {
+			return
+		}
+		delete(p.proposals, oldest)
+	}
+}
+
+// forget drops every proposal between two players.
+func (p *Pacts) forget(a, b string) {
+	for id, proposal := range p.proposals {
+		if pair(proposal.From, proposal.To) == pair(a, b) {
+			delete(p.proposals, id)
+		}
+	}
+}
+
+// Between is the pact two players have at now, if they have one.
+func (p *Pacts) Between(a, b string, now time.Time) (Pact, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pact, ok := p.pacts[pair(a, b)]
+	if !ok || !pact.activeAt(now) {
+		return Pact{}, false
+	}
+	return pact, true
+}
+
+// Allied reports whether two players' units can share a location.
+func (p *Pacts) Allied(a, b string, now time.Time) bool {
+	pact, ok := p.Between(a, b, now)
+	return ok && pact.Kind == routing.PactAlliance
+}
+
+// Proposal is the latest proposal from one player to another nobody has
+// answered yet.
+func (p *Pacts) Proposal(from, to string) (routing.Diplomacy, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var latest routing.Diplomacy
+	found := false
+	for _, proposal := range p.proposals {
+		if proposal.From == from && proposal.To == to && (!found || proposal.SentAt.After(latest.SentAt)) {
+			latest, found = proposal, true
+		}
+	}
+	return latest, found
+}
+
+// ProposalsTo is every unanswered proposal to a player, oldest first.
+func (p *Pacts) ProposalsTo(username string) []routing.Diplomacy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	proposals := []routing.Diplomacy{}
+	for _, proposal := range p.proposals {
+		if proposal.To == username {
+			proposals = append(proposals, proposal)
+		}
+	}
+	sort.Slice(proposals, func(i, j int) bool { return proposals[i].SentAt.Before(proposals[j].SentAt) })
+	return proposals
+}
+
+// Active is every pact in force at now, sorted by who is in it. Truces that
+// ran out are dropped.
+func (p *Pacts) Active(now time.Time) []Pact {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	active := []Pact{}
+	for players, pact := range p.pacts {
+		if !pact.activeAt(now) {
+			delete(p.pacts, players)
+			continue
+		}
+		active = append(active, pact)
+	}
+	sort.Slice(active, func(i, j int) bool {
+		if active[i].Players[0] != active[j].Players[0] {
+			return active[i].Players[0] < active[j].Players[0]
+		}
+		return active[i].Players[1] < active[j].Players[1]
+	})
+	return active
+}
+
+// Replace swaps every pact for pacts, like when a world is loaded. Pending
+// proposals are dropped.
+func (p *Pacts) Replace(pacts []Pact) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pacts = map[[2]string]Pact{}
+	p.proposals = map[[2]string]routing.Diplomacy{}
+	for _, pact := range pacts {
+		p.pacts[pair(pact.Players[0], pact.Players[1])] = pact
+	}
+}
+
+// ProposeMessage checks a propose command and turns it into a message for
+// the other player.
+func (gs *GameState) ProposeMessage(words []string) (routing.Diplomacy, error) {
+	if len(words) < 3 {
+		return routing.Diplomacy{}, errors.New("usage: propose <username> alliance|truce|non_aggression [duration]")
+	}
+	to, kind := words[1], routing.PactKind(words[2])
+	if to == gs.GetUsername() {
+		return routing.Diplomacy{}, errors.New("error: you can't make a pact with yourself")
+	}
+	err := ValidatePact(kind)
+	if err != nil {
+		return routing.Diplomacy{}, err
+	}
+	if pact, ok := gs.Pacts.Between(gs.GetUsername(), to, time.Now()); ok {
+		return routing.Diplomacy{}, fmt.Errorf("error: you already have a(n) %s with %s, break it first", pact.Kind, to)
+	}
+	msg := gs.diplomacy(routing.DiplomacyPropose, to)
+	msg.ID = fmt.Sprintf("%s-%d", gs.GetUsername(), msg.SentAt.UnixNano())
+	msg.Pact = kind
+	if kind == routing.PactTruce {
+		msg.Duration = DefaultTruce
+		if len(words) > 3 {
+			msg.Duration, err = time.ParseDuration(words[3])
+			if err != nil || msg.Duration <= 0 {
+				return routing.Diplomacy{}, fmt.Errorf("error: %s is not a duration like 10m", words[3])
+			}
+		}
+	}
+	return msg, nil
+}
+
+// AnswerMessage answers the latest proposal from the player named in an
+// accept or reject command.
+func (gs *GameState) AnswerMessage(words []string, action routing.DiplomacyAction) (routing.Diplomacy, error) {
+	if len(words) < 2 {
+		return routing.Diplomacy{}, fmt.Errorf("usage: %s <username>", action)
+	}
+	proposal, ok := gs.Pacts.Proposal(words[1], gs.GetUsername())
+	if !ok {
+		return routing.Diplomacy{}, fmt.Errorf("error: %s hasn't proposed anything", words[1])
+	}
+	msg := gs.diplomacy(action, proposal.From)
+	msg.ID = proposal.ID
+	msg.Pact = proposal.Pact
+	return msg, nil
+}
+
+// BreakMessage checks a break command and turns it into a message for the
+// other player.
+func (gs *GameState) BreakMessage(words []string) (routing.Diplomacy, error) {
+	if len(words) < 2 {
+		return routing.Diplomacy{}, errors.New("usage: break <username>")
+	}
+	pact, ok := gs.Pacts.Between(gs.GetUsername(), words[1], time.Now())
+	if !ok {
+		return routing.Diplomacy{}, fmt.Errorf("error: you have no pact with %s", words[1])
+	}
+	msg := gs.diplomacy(routing.DiplomacyBreak, words[1])
+	msg.Pact = pact.Kind
+	return msg, nil
+}
+
+func (gs *GameState) diplomacy(action routing.DiplomacyAction, to string) routing.Diplomacy {
+	return routing.Diplomacy{Action: action, From: gs.GetUsername(), To: to, SentAt: time.Now()}
+}
+
+// HandleDiplomacy applies a message another player sent and tells the
+// player about it.
+func (gs *GameState) HandleDiplomacy(msg routing.Diplomacy) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Diplomacy ====")
+	_, changed := gs.Pacts.Apply(msg, time.Now())
+	switch msg.Action {
+	case routing.DiplomacyPropose:
+		if msg.Pact == routing.PactTruce {
+			fmt.Printf("%s proposes a truce for %s.\n", msg.From, msg.Duration)
+		} else {
+			fmt.Printf("%s proposes a(n) %s.\n", msg.From, msg.Pact)
+		}
+		fmt.Printf("Answer with 'accept %s' or 'reject %s'.\n", msg.From, msg.From)
+	case routing.DiplomacyAccept:
+		if changed {
+			fmt.Printf("%s accepted your %s.\n", msg.From, msg.Pact)
+		}
+	case routing.DiplomacyReject:
+		fmt.Printf("%s rejected your %s.\n", msg.From, msg.Pact)
+	case routing.DiplomacyBreak:
+		if changed {
+			fmt.Printf("%s broke your %s!\n", msg.From, msg.Pact)
+		}
+	}
+}
+
+// CommandPacts lists the player's pacts and the proposals waiting for an
+// answer.
+func (gs *GameState) CommandPacts() {
+	username := gs.GetUsername()
+	found := false
+	for _, pact := range gs.Pacts.Active(time.Now()) {
+		if pact.Players[0] != username && pact.Players[1] != username {
+			continue
+		}
+		found = true
+		if pact.Until.IsZero() {
+			fmt.Printf("* %s with %s\n", pact.Kind, pact.Other(username))
+		} else {
+			fmt.Printf("* %s with %s until %s\n", pact.Kind, pact.Other(username), pact.Until.Format(time.TimeOnly))
+		}
+	}
+	for _, proposal := range gs.Pacts.ProposalsTo(username) {
+		found = true
+		fmt.Printf("* %s proposed a(n) %s\n", proposal.From, proposal.Pact)
+	}
+	if !found {
+		fmt.Println("You have no pacts and no proposals.")
+	}
+}
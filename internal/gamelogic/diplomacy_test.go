@@ -0,0 +1,132 @@
+package gamelogic
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func propose(id, from, to string, kind routing.PactKind) routing.Diplomacy {
+	return routing.Diplomacy{ID: id, Action: routing.DiplomacyPropose, From: from, To: to, Pact: kind}
+}
+
+func answer(proposal routing.Diplomacy, action routing.DiplomacyAction) routing.Diplomacy {
+	return routing.Diplomacy{ID: proposal.ID, Action: action, From: proposal.To, To: proposal.From, Pact: proposal.Pact}
+}
+
+func TestTruceExpires(t *testing.T) {
+	pacts := NewPacts()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	proposal := propose("1", "alice", "bob", routing.PactTruce)
+	proposal.Duration = 10 * time.Minute
+	pacts.Apply(proposal, start)
+
+	accept := answer(proposal, routing.DiplomacyAccept)
+	// Whatever time the sender claims, the truce starts when it arrives.
+	accept.SentAt = start.Add(24 * time.Hour)
+	pact, ok := pacts.Apply(accept, start.Add(time.Minute))
+	if !ok {
+		t.Fatal("accepting made no pact")
+	}
+	if !pact.Since.Equal(start.Add(time.Minute)) || !pact.Until.Equal(start.Add(11*time.Minute)) {
+		t.Errorf("truce runs from %s until %s", pact.Since, pact.Until)
+	}
+
+	if _, ok := pacts.Between("bob", "alice", start.Add(10*time.Minute)); !ok {
+		t.Error("the truce ended early")
+	}
+	if _, ok := pacts.Between("alice", "bob", start.Add(11*time.Minute)); ok {
+		t.Error("the truce outlasted its duration")
+	}
+	if active := pacts.Active(start.Add(12 * time.Minute)); len(active) != 0 {
+		t.Errorf("expired pacts are still active: %v", active)
+	}
+}
+
+func TestAllianceLastsUntilBroken(t *testing.T) {
+	pacts := NewPacts()
+	now := time.Now()
+	proposal := propose("1", "alice", "bob", routing.PactAlliance)
+	pacts.Apply(proposal, now)
+	pacts.Apply(answer(proposal, routing.DiplomacyAccept), now)
+
+	if !pacts.Allied("alice", "bob", now.Add(24*time.Hour)) {
+		t.Fatal("the alliance ran out")
+	}
+	if _, ok := pacts.Apply(routing.Diplomacy{Action: routing.DiplomacyBreak, From: "bob", To: "alice"}, now); !ok {
+		t.Fatal("breaking the alliance changed nothing")
+	}
+	if pacts.Allied("alice", "bob", now) {
+		t.Error("still allied after breaking it")
+	}
+}
+
+func TestProposalsExpire(t *testing.T) {
+	pacts := NewPacts()
+	start := time.Now()
+	proposal := propose("1", "alice", "bob", routing.PactNonAggression)
+	pacts.Apply(proposal, start)
+
+	if _, ok := pacts.Apply(answer(proposal, routing.DiplomacyAccept), start.Add(ProposalTTL+time.Second)); ok {
+		t.Error("an expired proposal was accepted")
+	}
+	if proposals := pacts.ProposalsTo("bob"); len(proposals) != 0 {
+		t.Errorf("expired proposals are kept: %v", proposals)
+	}
+}
+
+func TestProposalsAreCapped(t *testing.T) {
+	pacts := NewPacts()
+	start := time.Now()
+	for i := 0; i < MaxProposals+3; i++ {
+		pacts.Apply(propose(fmt.Sprint(i), "alice", "bob", routing.PactAlliance), start.Add(time.Duration(i)*time.Second))
+	}
+	proposals := pacts.ProposalsTo("bob")
+	if len(proposals) != MaxProposals {
+		t.Fatalf("alice has %d proposals out, want %d", len(proposals), MaxProposals)
+	}
+	if proposals[0].ID != "3" {
+		t.Errorf("the oldest proposal kept is %s, want 3", proposals[0].ID)
+	}
+}
+
+func TestProposalIDsAreKeptApart(t *testing.T) {
+	pacts := NewPacts()
+	now := time.Now()
+	proposal := propose("1", "alice", "bob", routing.PactAlliance)
+	pacts.Apply(proposal, now)
+	// Carol reusing the ID doesn't replace alice's proposal.
+	pacts.Apply(propose("1", "carol", "bob", routing.PactTruce), now)
+
+	pact, ok := pacts.Apply(answer(proposal, routing.DiplomacyAccept), now)
+	if !ok || pact.Kind != routing.PactAlliance || pact.Other("bob") != "alice" {
+		t.Errorf("bob accepted %+v", pact)
+	}
+}
+
+func TestVerifyDiplomacy(t *testing.T) {
+	msg := propose("1", "alice", "bob", routing.PactAlliance)
+	tests := []struct {
+		origin pubsub.Origin
+		ok     bool
+	}{
+		{pubsub.Origin{Key: routing.DiplomacyKey("bob", "alice"), UserID: "alice"}, true},
+		{pubsub.Origin{Key: routing.DiplomacyKey("bob", "alice"), UserID: "mallory"}, false},
+		{pubsub.Origin{Key: routing.DiplomacyKey("bob", "alice")}, false},
+		{pubsub.Origin{Key: routing.DiplomacyKey("carol", "alice"), UserID: "alice"}, false},
+	}
+	for _, tt := range tests {
+		if err := VerifyDiplomacy(tt.origin, msg); (err == nil) != tt.ok {
+			t.Errorf("%+v: %v", tt.origin, err)
+		}
+	}
+
+	msg.To = "*"
+	if err := VerifyDiplomacy(pubsub.Origin{Key: routing.DiplomacyKey("*", "alice"), UserID: "alice"}, msg); err == nil {
+		t.Error("a message to '*' got through")
+	}
+}
@@ -0,0 +1,87 @@
+package gamelogic
+
+import "fmt"
+
+// DefaultCosts is what a unit of each rank costs, used for ranks a map
+// doesn't price. Gold for gold, infantry is as strong as artillery and
+// cavalry pays for moving further.
+var DefaultCosts = map[UnitRank]int{
+	RankInfantry:  2,
+	RankCavalry:   10,
+	RankArtillery: 25,
+}
+
+const (
+	// DefaultIncome is paid for every territory a player controls.
+	DefaultIncome       = 2
+	DefaultStartingGold = 30
+)
+
+func (m *Map) buildEconomy() error {
+	costs := map[UnitRank]int{}
+	for rank, cost := range DefaultCosts {
+		costs[rank] = cost
+	}
+	for rank, cost := range m.Costs {
+		if _, ok := getAllRanks()[rank]; !ok {
+			return fmt.Errorf("'%s' is not a unit rank", rank)
+		}
+		if cost < 0 {
+			return fmt.Errorf("%s can't cost less than nothing", rank)
+		}
+		costs[rank] = cost
+	}
+	m.Costs = costs
+
+	if m.Income < 0 || m.StartingGold < 0 {
+		return fmt.Errorf("income and starting gold can't be negative")
+	}
+	if m.Income == 0 {
+		m.Income = DefaultIncome
+	}
+	if m.StartingGold == 0 {
+		m.StartingGold = DefaultStartingGold
+	}
+	return nil
+}
+
+// Cost is what spawning a unit of rank costs.
+func (m *Map) Cost(rank UnitRank) int {
+	return m.Costs[rank]
+}
+
+// CheckFunds fails if a balance can't pay for a unit of rank.
+func (m *Map) CheckFunds(balance int, rank UnitRank) error {
+	if balance < m.Cost(rank) {
+		return fmt.Errorf("error: a(n) %s costs %d gold, you have %d", rank, m.Cost(rank), balance)
+	}
+	return nil
+}
+
+// CheapestCost is the least a unit costs.
+func (m *Map) CheapestCost() int {
+	cheapest := -1
+	for _, cost := range m.Costs {
+		if cheapest < 0 || cost < cheapest {
+			cheapest = cost
+		}
+	}
+	return cheapest
+}
+
+// Territories is how many of territories a player controls, whether or not
+// they still have units there.
+func Territories(username string, territories map[Location]Territory) int {
+	controlled := 0
+	for _, territory := range territories {
+		if territory.Owner == username {
+			controlled++
+		}
+	}
+	return controlled
+}
+
+// IncomeOf is what a player earns per tick or turn.
+func (m *Map) IncomeOf(username string, territories map[Location]Territory) int {
+	return Territories(username, territories) * m.Income
+}
@@ -0,0 +1,55 @@
+package gamelogic
+
+import "testing"
+
+func TestMapEconomy(t *testing.T) {
+	m := DefaultMap()
+	if m.Income != DefaultIncome || m.StartingGold != DefaultStartingGold {
+		t.Errorf("the default map pays %d and starts with %d", m.Income, m.StartingGold)
+	}
+	if m.Cost(RankArtillery) != DefaultCosts[RankArtillery] || m.CheapestCost() != DefaultCosts[RankInfantry] {
+		t.Errorf("artillery costs %d and the cheapest unit %d", m.Cost(RankArtillery), m.CheapestCost())
+	}
+	if err := m.CheckFunds(DefaultCosts[RankCavalry], RankCavalry); err != nil {
+		t.Error(err)
+	}
+	if err := m.CheckFunds(DefaultCosts[RankCavalry]-1, RankCavalry); err == nil {
+		t.Error("cavalry was affordable with a gold too little")
+	}
+
+	priced := &Map{Territories: []Location{"home"}, Costs: map[UnitRank]int{RankCavalry: 0}, Income: 5}
+	if err := priced.build(); err != nil {
+		t.Fatal(err)
+	}
+	if priced.Cost(RankCavalry) != 0 || priced.Cost(RankInfantry) != DefaultCosts[RankInfantry] || priced.CheapestCost() != 0 {
+		t.Errorf("cavalry costs %d, infantry %d", priced.Cost(RankCavalry), priced.Cost(RankInfantry))
+	}
+
+	for _, bad := range []*Map{
+		{Territories: []Location{"home"}, Costs: map[UnitRank]int{RankInfantry: -1}},
+		{Territories: []Location{"home"}, Costs: map[UnitRank]int{"dragon": 3}},
+		{Territories: []Location{"home"}, Income: -1},
+	} {
+		if err := bad.build(); err == nil {
+			t.Errorf("map with costs %v and income %d built", bad.Costs, bad.Income)
+		}
+	}
+}
+
+func TestIncomeOfControlledTerritories(t *testing.T) {
+	m := DefaultMap()
+	territories := map[Location]Territory{
+		"europe": {Location: "europe", Owner: "alice"},
+		"asia":   {Location: "asia", Owner: "alice", Forts: 2},
+		"africa": {Location: "africa", Owner: "bob"},
+	}
+	if n := Territories("alice", territories); n != 2 {
+		t.Errorf("alice controls %d territories, want 2", n)
+	}
+	if income := m.IncomeOf("alice", territories); income != 2*DefaultIncome {
+		t.Errorf("alice earns %d, want %d", income, 2*DefaultIncome)
+	}
+	if income := m.IncomeOf("carol", territories); income != 0 {
+		t.Errorf("carol earns %d without any territory", income)
+	}
+}
@@ -39,14 +39,3 @@ func getAllRanks() map[UnitRank]struct{} {
 		RankArtillery: {},
 	}
 }
-
-func getAllLocations() map[Location]struct{} {
-	return map[Location]struct{}{
-		"americas":   {},
-		"europe":     {},
-		"africa":     {},
-		"asia":       {},
-		"australia":  {},
-		"antarctica": {},
-	}
-}
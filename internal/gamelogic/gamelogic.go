@@ -17,7 +17,25 @@ func PrintClientHelp() {
 	fmt.Println("* spawn <location> <rank>")
 	fmt.Println("    example:")
 	fmt.Println("    spawn europe infantry")
+	fmt.Println("* fortify <location>")
+	fmt.Println("    example:")
+	fmt.Println("    fortify europe")
+	fmt.Println("* propose <username> alliance|truce|non_aggression [duration]")
+	fmt.Println("    example:")
+	fmt.Println("    propose bob truce 10m")
+	fmt.Println("* accept <username>")
+	fmt.Println("* reject <username>")
+	fmt.Println("* break <username>")
+	fmt.Println("* pacts")
+	fmt.Println("* say [-a] <message>")
+	fmt.Println("    example:")
+	fmt.Println("    say -a meet me in europe")
+	fmt.Println("* whisper <username> <message>")
+	fmt.Println("* history")
 	fmt.Println("* status")
+	fmt.Println("* map")
+	fmt.Println("* save")
+	fmt.Println("* load")
 	fmt.Println("* spam <n>")
 	fmt.Println("    example:")
 	fmt.Println("    spam 5")
@@ -25,6 +43,22 @@ func PrintClientHelp() {
 	fmt.Println("* help")
 }
 
+// ValidateUsername refuses names that can't be one word of a routing key.
+// A dot splits the key and "*" or "#" would turn a player's bindings into
+// wildcards matching everyone else's messages. Names are letters, digits,
+// '_' and '-', like the RabbitMQ users rabbit.sh adds.
+func ValidateUsername(username string) error {
+	if username == "" {
+		return errors.New("you must enter a username")
+	}
+	for _, r := range username {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '_' || r == '-') {
+			return fmt.Errorf("usernames can't contain '%c'", r)
+		}
+	}
+	return nil
+}
+
 func ClientWelcome() (string, error) {
 	fmt.Println("Welcome to the Peril client!")
 	fmt.Println("Please enter your username:")
@@ -33,6 +67,10 @@ func ClientWelcome() (string, error) {
 		return "", errors.New("you must enter a username. goodbye")
 	}
 	username := words[0]
+	err := ValidateUsername(username)
+	if err != nil {
+		return "", fmt.Errorf("%w. goodbye", err)
+	}
 	fmt.Printf("Welcome, %s!\n", username)
 	PrintClientHelp()
 	return username, nil
@@ -42,6 +80,16 @@ func PrintServerHelp() {
 	fmt.Println("Possible commands:")
 	fmt.Println("* pause")
 	fmt.Println("* resume")
+	fmt.Println("* turns on|off")
+	fmt.Println("* endturn")
+	fmt.Println("* players")
+	fmt.Println("* pacts")
+	fmt.Println("* stats")
+	fmt.Println("* logs [-u username] [-since 10m] [-until 5m] [-n 20] [-f] [text]")
+	fmt.Println("    example:")
+	fmt.Println("    logs -u alice -since 1h war")
+	fmt.Println("* save")
+	fmt.Println("* load")
 	fmt.Println("* quit")
 	fmt.Println("* help")
 }
@@ -77,6 +125,9 @@ func PrintQuit() {
 }
 
 func (gs *GameState) CommandStatus() {
+	if gs.IsOver() {
+		fmt.Println("The game is over.")
+	}
 	if gs.isPaused() {
 		fmt.Println("The game is paused.")
 		return
@@ -84,9 +135,63 @@ func (gs *GameState) CommandStatus() {
 		fmt.Println("The game is not paused.")
 	}
 
+	if turn := gs.getTurn(); turn.Turn > 0 {
+		fmt.Printf("It is turn %d.\n", turn.Turn)
+	}
+
 	p := gs.GetPlayerSnap()
 	fmt.Printf("You are %s, and you have %d units.\n", p.Username, len(p.Units))
+	territories := gs.getTerritoriesSnap()
+	fmt.Printf("Treasury: %d gold, earning %d from %d territories.\n", gs.GetBalance(), gs.Map.IncomeOf(p.Username, territories), Territories(p.Username, territories))
+	fmt.Printf("Costs: infantry %d, cavalry %d, artillery %d, fortification %d.\n", gs.Map.Cost(RankInfantry), gs.Map.Cost(RankCavalry), gs.Map.Cost(RankArtillery), gs.Map.FortCost)
+	controlled := []string{}
+	for _, territory := range gs.Map.SortedTerritories(territories) {
+		if territory.Owner == p.Username {
+			controlled = append(controlled, string(territory.Location))
+		}
+	}
+	if len(controlled) > 0 {
+		fmt.Printf("You control %s.\n", strings.Join(controlled, ", "))
+	} else {
+		fmt.Println("You don't control any territories.")
+	}
 	for _, unit := range p.Units {
-		fmt.Printf("* %v: %v, %v\n", unit.ID, unit.Location, unit.Rank)
+		fmt.Printf("* %v: %v (%s), %v\n", unit.ID, unit.Location, gs.describeControl(gs.GetTerritory(unit.Location)), unit.Rank)
+	}
+}
+
+// CommandMap lists every territory, who controls it, how well it is
+// defended and where it leads.
+func (gs *GameState) CommandMap() {
+	units := map[Location]int{}
+	for _, unit := range gs.GetPlayerSnap().Units {
+		units[unit.Location]++
+	}
+	fmt.Printf("Map: %s\n", gs.Map.Name)
+	for _, location := range gs.Map.Territories {
+		territory := gs.GetTerritory(location)
+		fmt.Printf("* %s: %s, defense x%.2f", location, gs.describeControl(territory), gs.Map.DefenseOf(territory))
+		if territory.Forts > 0 {
+			fmt.Printf(", %d/%d fortifications", territory.Forts, gs.Map.MaxForts)
+		}
+		if units[location] > 0 {
+			fmt.Printf(", %d of your units", units[location])
+		}
+		fmt.Println()
+		neighbors := []string{}
+		for _, neighbor := range gs.Map.Neighbors(location) {
+			neighbors = append(neighbors, string(neighbor))
+		}
+		fmt.Printf("    next to %s\n", strings.Join(neighbors, ", "))
+	}
+}
+
+func (gs *GameState) describeControl(territory Territory) string {
+	switch territory.Owner {
+	case "":
+		return "unclaimed"
+	case gs.GetUsername():
+		return "yours"
 	}
+	return "held by " + territory.Owner
 }
@@ -0,0 +1,211 @@
+package gamelogic
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Map is the board a game is played on. Units can only move along its edges,
+// and only as many territories per move as their rank allows.
+type Map struct {
+	Name        string     `yaml:"name" json:"name"`
+	Territories []Location `yaml:"territories" json:"territories"`
+	// Edges connect two territories both ways.
+	Edges  [][2]Location    `yaml:"edges" json:"edges"`
+	Ranges map[UnitRank]int `yaml:"ranges,omitempty" json:"ranges,omitempty"`
+	// Costs, Income and StartingGold are the economy games on this map use.
+	Costs        map[UnitRank]int `yaml:"costs,omitempty" json:"costs,omitempty"`
+	Income       int              `yaml:"income,omitempty" json:"income,omitempty"`
+	StartingGold int              `yaml:"starting_gold,omitempty" json:"starting_gold,omitempty"`
+	// Defense is the multiplier of every territory, the rest is how
+	// fortifications work on this map.
+	Defense   map[Location]float64 `yaml:"defense,omitempty" json:"defense,omitempty"`
+	FortCost  int                  `yaml:"fort_cost,omitempty" json:"fort_cost,omitempty"`
+	FortBonus float64              `yaml:"fort_bonus,omitempty" json:"fort_bonus,omitempty"`
+	MaxForts  int                  `yaml:"max_forts,omitempty" json:"max_forts,omitempty"`
+
+	adjacent map[Location][]Location
+}
+
+// DefaultRanges is how many territories a unit of each rank moves at most,
+// used for ranks a map doesn't set.
+var DefaultRanges = map[UnitRank]int{
+	RankInfantry:  1,
+	RankCavalry:   2,
+	RankArtillery: 1,
+}
+
+// DefaultMap is the six continents Peril has always been played on.
+func DefaultMap() *Map {
+	m := &Map{
+		Name:        "continents",
+		Territories: []Location{"americas", "europe", "africa", "asia", "australia", "antarctica"},
+		Edges: [][2]Location{
+			{"americas", "europe"},
+			{"americas", "africa"},
+			{"americas", "asia"},
+			{"americas", "antarctica"},
+			{"europe", "africa"},
+			{"europe", "asia"},
+			{"africa", "asia"},
+			{"africa", "antarctica"},
+			{"asia", "australia"},
+			{"australia", "antarctica"},
+		},
+	}
+	err := m.build()
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// LoadMap reads a map from a .yaml, .yml or .json file.
+func LoadMap(path string) (*Map, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Map{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, m)
+	case ".json":
+		err = json.Unmarshal(data, m)
+	default:
+		return nil, fmt.Errorf("unknown map format '%s', use .yaml or .json", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading map '%s': %w", path, err)
+	}
+	err = m.build()
+	if err != nil {
+		return nil, fmt.Errorf("map '%s' is invalid: %w", path, err)
+	}
+	return m, nil
+}
+
+// build checks the map and works out which territories are next to each
+// other.
+func (m *Map) build() error {
+	if len(m.Territories) == 0 {
+		return fmt.Errorf("it has no territories")
+	}
+	m.adjacent = map[Location][]Location{}
+	for _, territory := range m.Territories {
+		// Commands are split on spaces, so names can't have any.
+		if len(strings.Fields(string(territory))) != 1 || strings.TrimSpace(string(territory)) != string(territory) {
+			return fmt.Errorf("'%s' can't be used as a territory name", territory)
+		}
+		if _, ok := m.adjacent[territory]; ok {
+			return fmt.Errorf("territory '%s' is listed twice", territory)
+		}
+		m.adjacent[territory] = []Location{}
+	}
+	for _, edge := range m.Edges {
+		for _, end := range edge {
+			if !m.HasLocation(end) {
+				return fmt.Errorf("edge %s-%s goes to '%s', which isn't a territory", edge[0], edge[1], end)
+			}
+		}
+		if edge[0] == edge[1] {
+			return fmt.Errorf("edge %s-%s goes nowhere", edge[0], edge[1])
+		}
+		m.adjacent[edge[0]] = append(m.adjacent[edge[0]], edge[1])
+		m.adjacent[edge[1]] = append(m.adjacent[edge[1]], edge[0])
+	}
+
+	ranges := map[UnitRank]int{}
+	for rank, moves := range DefaultRanges {
+		ranges[rank] = moves
+	}
+	for rank, moves := range m.Ranges {
+		if _, ok := getAllRanks()[rank]; !ok {
+			return fmt.Errorf("'%s' is not a unit rank", rank)
+		}
+		if moves < 1 {
+			return fmt.Errorf("%s has to be able to move at least 1 territory", rank)
+		}
+		ranges[rank] = moves
+	}
+	m.Ranges = ranges
+	err := m.buildEconomy()
+	if err != nil {
+		return err
+	}
+	return m.buildDefense()
+}
+
+func (m *Map) HasLocation(location Location) bool {
+	_, ok := m.adjacent[location]
+	return ok
+}
+
+func (m *Map) ValidateLocation(location Location) error {
+	if !m.HasLocation(location) {
+		return fmt.Errorf("error: %s is not a valid location", location)
+	}
+	return nil
+}
+
+// Neighbors are the territories one move away, sorted.
+func (m *Map) Neighbors(location Location) []Location {
+	neighbors := append([]Location{}, m.adjacent[location]...)
+	sort.Slice(neighbors, func(i, j int) bool { return neighbors[i] < neighbors[j] })
+	return neighbors
+}
+
+// Distance is the fewest territories between from and to, or -1 if there is
+// no way between them.
+func (m *Map) Distance(from, to Location) int {
+	if !m.HasLocation(from) || !m.HasLocation(to) {
+		return -1
+	}
+	distances := map[Location]int{from: 0}
+	queue := []Location{from}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if current == to {
+			return distances[current]
+		}
+		for _, next := range m.adjacent[current] {
+			if _, seen := distances[next]; !seen {
+				distances[next] = distances[current] + 1
+				queue = append(queue, next)
+			}
+		}
+	}
+	return -1
+}
+
+// Range is how many territories a unit of rank can move at once.
+func (m *Map) Range(rank UnitRank) int {
+	return m.Ranges[rank]
+}
+
+// ValidateMove checks a unit can get to a territory in one move.
+func (m *Map) ValidateMove(unit Unit, to Location) error {
+	err := m.ValidateLocation(to)
+	if err != nil {
+		return err
+	}
+	if unit.Location == to {
+		return nil
+	}
+	distance := m.Distance(unit.Location, to)
+	if distance < 0 {
+		return fmt.Errorf("error: there is no way from %s to %s", unit.Location, to)
+	}
+	if distance > m.Range(unit.Rank) {
+		return fmt.Errorf("error: unit %v is a(n) %s, which moves up to %d territories, but %s is %d away from %s", unit.ID, unit.Rank, m.Range(unit.Rank), to, distance, unit.Location)
+	}
+	return nil
+}
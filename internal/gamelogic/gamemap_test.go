@@ -0,0 +1,95 @@
+package gamelogic
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMapDistance(t *testing.T) {
+	m := DefaultMap()
+	tests := []struct {
+		from, to Location
+		want     int
+	}{
+		{"europe", "europe", 0},
+		{"europe", "asia", 1},
+		{"europe", "australia", 2},
+		{"australia", "europe", 2},
+		{"europe", "atlantis", -1},
+	}
+	for _, tt := range tests {
+		if got := m.Distance(tt.from, tt.to); got != tt.want {
+			t.Errorf("distance from %s to %s is %d, want %d", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestMapRanges(t *testing.T) {
+	m := DefaultMap()
+	tests := []struct {
+		rank UnitRank
+		to   Location
+		ok   bool
+	}{
+		{RankInfantry, "asia", true},
+		{RankInfantry, "australia", false},
+		{RankCavalry, "australia", true},
+		{RankArtillery, "australia", false},
+		{RankCavalry, "atlantis", false},
+	}
+	for _, tt := range tests {
+		err := m.ValidateMove(Unit{ID: 1, Rank: tt.rank, Location: "europe"}, tt.to)
+		if (err == nil) != tt.ok {
+			t.Errorf("moving %s from europe to %s: %v", tt.rank, tt.to, err)
+		}
+	}
+}
+
+func TestLoadMap(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	m, err := LoadMap(write("islands.yaml", `
+name: islands
+territories: [north, middle, south, reef]
+edges:
+  - [north, middle]
+  - [middle, south]
+ranges:
+  infantry: 2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Range(RankInfantry) != 2 || m.Range(RankCavalry) != DefaultRanges[RankCavalry] {
+		t.Errorf("infantry moves %d and cavalry %d", m.Range(RankInfantry), m.Range(RankCavalry))
+	}
+	if d := m.Distance("north", "south"); d != 2 {
+		t.Errorf("north to south is %d away, want 2", d)
+	}
+	if d := m.Distance("north", "reef"); d != -1 {
+		t.Errorf("the unconnected reef is %d away", d)
+	}
+	if err := m.ValidateMove(Unit{Rank: RankInfantry, Location: "north"}, "south"); err != nil {
+		t.Error(err)
+	}
+
+	for name, content := range map[string]string{
+		"dangling.yaml": "territories: [north]\nedges: [[north, south]]\n",
+		"spaces.yaml":   "territories: [north pole]\n",
+		"twice.yaml":    "territories: [north, north]\n",
+		"ranges.yaml":   "territories: [north]\nranges: {infantry: 0}\n",
+		"empty.json":    "{}",
+	} {
+		if _, err := LoadMap(write(name, content)); err == nil {
+			t.Errorf("%s loaded", name)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package gamelogic
+
+import (
+	"fmt"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func (gs *GameState) HandleGameOver(over routing.GameOver) {
+	defer fmt.Println("------------------------")
+	gs.mu.Lock()
+	gs.GameOver = &over
+	gs.mu.Unlock()
+
+	fmt.Println()
+	fmt.Println("==== Game Over ====")
+	switch over.Winner {
+	case "":
+		fmt.Printf("Nobody won, %s.\n", over.Reason)
+	case gs.GetUsername():
+		fmt.Printf("You have won the game, %s!\n", over.Reason)
+	default:
+		fmt.Printf("%s has won the game, %s.\n", over.Winner, over.Reason)
+	}
+	for i, standing := range over.Standings {
+		status := ""
+		if standing.Eliminated {
+			status = " (eliminated)"
+		}
+		fmt.Printf("%d. %s: %d territories, power %d, %d gold%s\n", i+1, standing.Username, standing.Territories, standing.Power, standing.Gold, status)
+	}
+}
+
+// IsOver reports whether the server has announced the end of the game.
+func (gs *GameState) IsOver() bool {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.GameOver != nil
+}
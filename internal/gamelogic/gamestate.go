@@ -1,13 +1,31 @@
 package gamelogic
 
 import (
+	"fmt"
 	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
 )
 
 type GameState struct {
 	Player Player
 	Paused bool
-	mu     *sync.RWMutex
+	// Balance is the gold in the player's treasury, as the server last said.
+	Balance int
+	// Turn is the last turn the server announced.
+	Turn routing.TurnState
+	// GameOver is set once the server says the game has ended.
+	GameOver *routing.GameOver
+	// Map is the board every location is checked against, the six
+	// continents unless it is replaced before the game starts.
+	Map *Map
+	// Pacts are the player's pacts with others and the proposals they
+	// haven't answered.
+	Pacts *Pacts
+	// territories is who controls what, as the server last said.
+	territories map[Location]Territory
+	mu          *sync.RWMutex
 }
 
 func NewGameState(username string) *GameState {
@@ -16,8 +34,11 @@ func NewGameState(username string) *GameState {
 			Username: username,
 			Units:    map[int]Unit{},
 		},
-		Paused: false,
-		mu:     &sync.RWMutex{},
+		Paused:      false,
+		Map:         DefaultMap(),
+		Pacts:       NewPacts(),
+		territories: map[Location]Territory{},
+		mu:          &sync.RWMutex{},
 	}
 }
 
@@ -45,13 +66,20 @@ func (gs *GameState) addUnit(u Unit) {
 	gs.Player.Units[u.ID] = u
 }
 
-func (gs *GameState) removeUnitsInLocation(loc Location) {
+func (gs *GameState) removeUnits(units []Unit) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
-	for k, v := range gs.Player.Units {
-		if v.Location == loc {
-			delete(gs.Player.Units, k)
-		}
+	for _, u := range units {
+		delete(gs.Player.Units, u.ID)
+	}
+}
+
+func (gs *GameState) replaceUnits(units []Unit) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.Player.Units = map[int]Unit{}
+	for _, u := range units {
+		gs.Player.Units[u.ID] = u
 	}
 }
 
@@ -61,6 +89,54 @@ func (gs *GameState) UpdateUnit(u Unit) {
 	gs.Player.Units[u.ID] = u
 }
 
+func (gs *GameState) GetBalance() int {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.Balance
+}
+
+func (gs *GameState) setBalance(balance int) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.Balance = balance
+}
+
+// GetTerritory is who controls location, with no owner if nobody does.
+func (gs *GameState) GetTerritory(location Location) Territory {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	territory, ok := gs.territories[location]
+	if !ok {
+		return Territory{Location: location}
+	}
+	return territory
+}
+
+func (gs *GameState) getTerritoriesSnap() map[Location]Territory {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	territories := map[Location]Territory{}
+	for location, territory := range gs.territories {
+		territories[location] = territory
+	}
+	return territories
+}
+
+func (gs *GameState) setTerritory(territory Territory) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.territories[territory.Location] = territory
+}
+
+func (gs *GameState) replaceTerritories(territories []Territory) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.territories = map[Location]Territory{}
+	for _, territory := range territories {
+		gs.territories[territory.Location] = territory
+	}
+}
+
 func (gs *GameState) GetUsername() string {
 	return gs.Player.Username
 }
@@ -94,3 +170,43 @@ func (gs *GameState) GetPlayerSnap() Player {
 		Units:    Units,
 	}
 }
+
+// GameStateSnapshot is what a client saves of its game state.
+type GameStateSnapshot struct {
+	Player      Player
+	Paused      bool
+	Balance     int
+	Territories []Territory
+	Pacts       []Pact
+}
+
+func (gs *GameState) Snapshot() GameStateSnapshot {
+	return GameStateSnapshot{
+		Player:      gs.GetPlayerSnap(),
+		Paused:      gs.isPaused(),
+		Balance:     gs.GetBalance(),
+		Territories: gs.Map.SortedTerritories(gs.getTerritoriesSnap()),
+		Pacts:       gs.Pacts.Active(time.Now()),
+	}
+}
+
+// Restore replaces the game state with a snapshot of the same player.
+func (gs *GameState) Restore(snap GameStateSnapshot) error {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if snap.Player.Username != gs.Player.Username {
+		return fmt.Errorf("snapshot belongs to %s, not %s", snap.Player.Username, gs.Player.Username)
+	}
+	gs.Player.Units = map[int]Unit{}
+	for id, unit := range snap.Player.Units {
+		gs.Player.Units[id] = unit
+	}
+	gs.Paused = snap.Paused
+	gs.Balance = snap.Balance
+	gs.territories = map[Location]Territory{}
+	for _, territory := range snap.Territories {
+		gs.territories[territory.Location] = territory
+	}
+	gs.Pacts.Replace(snap.Pacts)
+	return nil
+}
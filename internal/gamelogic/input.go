@@ -0,0 +1,40 @@
+package gamelogic
+
+// InputReader reads commands from stdin on its own goroutine so callers can
+// wait on a command and a shutdown signal at the same time.
+type InputReader struct {
+	next  chan struct{}
+	lines chan []string
+}
+
+func NewInputReader() *InputReader {
+	r := &InputReader{
+		next:  make(chan struct{}, 1),
+		lines: make(chan []string),
+	}
+
+	go func() {
+		defer close(r.lines)
+		for range r.next {
+			words := GetInput()
+			if words == nil {
+				// stdin was closed, there is nothing more to read
+				return
+			}
+			r.lines <- words
+		}
+	}()
+
+	return r
+}
+
+// Next prompts for the next command. The returned channel yields it, and is
+// closed once stdin runs out.
+func (r *InputReader) Next() <-chan []string {
+	select {
+	case r.next <- struct{}{}:
+	default:
+		// the previous prompt hasn't been answered yet
+	}
+	return r.lines
+}
@@ -0,0 +1,224 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+type IntentKind string
+
+const (
+	// IntentJoin asks for a snapshot of the player's units, which is how a
+	// client finds out what it owns after starting.
+	IntentJoin  IntentKind = "join"
+	IntentSpawn IntentKind = "spawn"
+	IntentMove  IntentKind = "move"
+	// IntentFortify builds a fortification in a territory the player
+	// controls.
+	IntentFortify IntentKind = "fortify"
+)
+
+// Intent is something a player asks the server to do. Nothing changes until
+// the server has checked it and sent out the StateDeltas it led to.
+type Intent struct {
+	// ID is picked by the client so it can tell which intent a rejection
+	// is for.
+	ID       string
+	Kind     IntentKind
+	Username string
+	Rank     UnitRank
+	Location Location
+	UnitIDs  []int
+}
+
+type DeltaKind string
+
+const (
+	// DeltaSnapshot replaces all of a player's units.
+	DeltaSnapshot  DeltaKind = "snapshot"
+	DeltaSpawned   DeltaKind = "spawned"
+	DeltaMoved     DeltaKind = "moved"
+	DeltaDestroyed DeltaKind = "destroyed"
+	// DeltaWarDeclared comes right before the DeltaWar with its result, War
+	// only names who is fighting where.
+	DeltaWarDeclared DeltaKind = "war_declared"
+	DeltaWar         DeltaKind = "war"
+	// DeltaRejected tells a player one of their intents was refused.
+	DeltaRejected DeltaKind = "rejected"
+	// DeltaOrdered tells a player one of their intents will be carried out
+	// at the end of the turn.
+	DeltaOrdered DeltaKind = "ordered"
+	// DeltaIncome pays a player for the territories they hold.
+	DeltaIncome DeltaKind = "income"
+	// DeltaEliminated means a player has no units left and can't afford
+	// any more.
+	DeltaEliminated DeltaKind = "eliminated"
+	// DeltaCaptured gives control of a territory to the player, Territory
+	// is what it looks like now.
+	DeltaCaptured DeltaKind = "captured"
+	// DeltaFortified is a fortification the player built.
+	DeltaFortified DeltaKind = "fortified"
+)
+
+// StateDelta is one change to the server's world. Seq increases by one for
+// every delta the server sends.
+type StateDelta struct {
+	Seq      uint64
+	Kind     DeltaKind
+	Username string
+	IntentID string
+	Units    []Unit
+	Location Location
+	War      *WarResult
+	Reason   string
+	// Turn is the turn an order or its outcome belongs to, 0 outside of
+	// turn mode.
+	Turn int
+	// Gold is how much the player's treasury changed by and Balance what is
+	// in it afterwards, on snapshots, spawns, fortifications and income.
+	Gold    int
+	Balance int
+	// Territory is the territory a capture or fortification changed,
+	// Territories every controlled territory on snapshots.
+	Territory   *Territory
+	Territories []Territory
+}
+
+// Private reports whether only the player the delta is about may see it.
+// Accepted and refused orders would tell everyone else what a player is
+// planning before the turn ends.
+func (d StateDelta) Private() bool {
+	return d.Kind == DeltaOrdered || d.Kind == DeltaRejected
+}
+
+// WarResult is a war the server fought. Winner and Loser are empty after a
+// draw. Depending on the combat rules both sides may lose units, or the loser
+// may keep some.
+type WarResult struct {
+	Attacker      string
+	Defender      string
+	Location      Location
+	AttackerPower int
+	DefenderPower int
+	Winner        string
+	Loser         string
+	// AttackerLosses and DefenderLosses are the units each side lost.
+	AttackerLosses []Unit
+	DefenderLosses []Unit
+}
+
+func ValidateRank(rank UnitRank) error {
+	if _, ok := getAllRanks()[rank]; !ok {
+		return fmt.Errorf("error: %s is not a valid unit", rank)
+	}
+	return nil
+}
+
+// SpawnIntent checks a spawn command and turns it into an intent without
+// changing the game state. The unit shows up once the server confirms it.
+func (gs *GameState) SpawnIntent(words []string) (Intent, error) {
+	err := gs.checkTurn()
+	if err != nil {
+		return Intent{}, err
+	}
+	if len(words) < 3 {
+		return Intent{}, errors.New("usage: spawn <location> <rank>")
+	}
+
+	location := Location(words[1])
+	err = gs.Map.ValidateLocation(location)
+	if err != nil {
+		return Intent{}, err
+	}
+	rank := UnitRank(words[2])
+	err = ValidateRank(rank)
+	if err != nil {
+		return Intent{}, err
+	}
+	err = gs.Map.CheckFunds(gs.GetBalance(), rank)
+	if err != nil {
+		return Intent{}, err
+	}
+
+	return Intent{
+		Kind:     IntentSpawn,
+		Username: gs.GetUsername(),
+		Rank:     rank,
+		Location: location,
+	}, nil
+}
+
+// FortifyIntent checks a fortify command and turns it into an intent. The
+// fortification is built once the server confirms it.
+func (gs *GameState) FortifyIntent(words []string) (Intent, error) {
+	if gs.isPaused() {
+		return Intent{}, errors.New("the game is paused, you can not fortify")
+	}
+	err := gs.checkTurn()
+	if err != nil {
+		return Intent{}, err
+	}
+	if len(words) < 2 {
+		return Intent{}, errors.New("usage: fortify <location>")
+	}
+	location := Location(words[1])
+	err = gs.Map.ValidateLocation(location)
+	if err != nil {
+		return Intent{}, err
+	}
+	err = gs.Map.CheckFortify(gs.GetUsername(), gs.GetTerritory(location), gs.GetBalance())
+	if err != nil {
+		return Intent{}, err
+	}
+
+	return Intent{
+		Kind:     IntentFortify,
+		Username: gs.GetUsername(),
+		Location: location,
+	}, nil
+}
+
+// MoveIntent checks a move command and turns it into an intent without
+// changing the game state. The units move once the server confirms it.
+func (gs *GameState) MoveIntent(words []string) (Intent, error) {
+	if gs.isPaused() {
+		return Intent{}, errors.New("the game is paused, you can not move units")
+	}
+	err := gs.checkTurn()
+	if err != nil {
+		return Intent{}, err
+	}
+	if len(words) < 3 {
+		return Intent{}, errors.New("usage: move <location> <unitID> <unitID> <unitID> etc")
+	}
+	location := Location(words[1])
+	err = gs.Map.ValidateLocation(location)
+	if err != nil {
+		return Intent{}, err
+	}
+
+	unitIDs := []int{}
+	for _, word := range words[2:] {
+		unitID, err := strconv.Atoi(word)
+		if err != nil {
+			return Intent{}, fmt.Errorf("error: %s is not a valid unit ID", word)
+		}
+		unit, ok := gs.GetUnit(unitID)
+		if !ok {
+			return Intent{}, fmt.Errorf("error: unit with ID %v not found", unitID)
+		}
+		err = gs.Map.ValidateMove(unit, location)
+		if err != nil {
+			return Intent{}, err
+		}
+		unitIDs = append(unitIDs, unitID)
+	}
+
+	return Intent{
+		Kind:     IntentMove,
+		Username: gs.GetUsername(),
+		Location: location,
+		UnitIDs:  unitIDs,
+	}, nil
+}
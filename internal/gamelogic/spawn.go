@@ -11,9 +11,9 @@ func (gs *GameState) CommandSpawn(words []string) error {
 	}
 
 	locationName := words[1]
-	locations := getAllLocations()
-	if _, ok := locations[Location(locationName)]; !ok {
-		return fmt.Errorf("error: %s is not a valid location", locationName)
+	err := gs.Map.ValidateLocation(Location(locationName))
+	if err != nil {
+		return err
 	}
 
 	rank := words[2]
@@ -0,0 +1,104 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Territory is who controls a location. A player takes control by being the
+// only one with units there and keeps it until someone else is, even after
+// leaving. Forts are the fortifications built there.
+type Territory struct {
+	Location Location
+	Owner    string
+	Forts    int
+}
+
+const (
+	// DefaultDefense is what the power of units defending a territory their
+	// player controls is multiplied by, for territories a map doesn't set.
+	DefaultDefense = 1.25
+	// DefaultFortCost, DefaultFortBonus and DefaultMaxForts are what a
+	// fortification costs, how much each one adds to the defense multiplier
+	// and how many a territory can have.
+	DefaultFortCost  = 15
+	DefaultFortBonus = 0.25
+	DefaultMaxForts  = 3
+)
+
+func (m *Map) buildDefense() error {
+	defense := map[Location]float64{}
+	for _, territory := range m.Territories {
+		defense[territory] = DefaultDefense
+	}
+	for location, multiplier := range m.Defense {
+		if !m.HasLocation(location) {
+			return fmt.Errorf("defense is set for '%s', which isn't a territory", location)
+		}
+		if multiplier < 1 {
+			return fmt.Errorf("the defense of %s can't be below 1", location)
+		}
+		defense[location] = multiplier
+	}
+	m.Defense = defense
+
+	if m.FortCost < 0 || m.FortBonus < 0 || m.MaxForts < 0 {
+		return errors.New("fortification cost, bonus and maximum can't be negative")
+	}
+	if m.FortCost == 0 {
+		m.FortCost = DefaultFortCost
+	}
+	if m.FortBonus == 0 {
+		m.FortBonus = DefaultFortBonus
+	}
+	if m.MaxForts == 0 {
+		m.MaxForts = DefaultMaxForts
+	}
+	return nil
+}
+
+// DefenseOf is what the power of the units defending a territory for its
+// owner is multiplied by, forts included.
+func (m *Map) DefenseOf(territory Territory) float64 {
+	return m.Defense[territory.Location] + float64(territory.Forts)*m.FortBonus
+}
+
+// CheckFortify fails if a player can't build a fortification in territory
+// with balance.
+func (m *Map) CheckFortify(username string, territory Territory, balance int) error {
+	if territory.Owner != username {
+		return fmt.Errorf("error: you don't control %s", territory.Location)
+	}
+	if territory.Forts >= m.MaxForts {
+		return fmt.Errorf("error: %s already has %d fortifications, the most it can have", territory.Location, territory.Forts)
+	}
+	if balance < m.FortCost {
+		return fmt.Errorf("error: a fortification costs %d gold, you have %d", m.FortCost, balance)
+	}
+	return nil
+}
+
+// applyDefense scales defending power by a defense multiplier.
+func applyDefense(power int, defense float64) int {
+	if defense <= 1 {
+		return power
+	}
+	return int(math.Round(float64(power) * defense))
+}
+
+// SortedTerritories lists the controlled territories in the order of the
+// map.
+func (m *Map) SortedTerritories(territories map[Location]Territory) []Territory {
+	order := map[Location]int{}
+	for i, location := range m.Territories {
+		order[location] = i
+	}
+	sorted := make([]Territory, 0, len(territories))
+	for _, territory := range territories {
+		sorted = append(sorted, territory)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return order[sorted[i].Location] < order[sorted[j].Location] })
+	return sorted
+}
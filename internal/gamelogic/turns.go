@@ -0,0 +1,52 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func (gs *GameState) HandleTurn(ts routing.TurnState) {
+	defer fmt.Println("------------------------")
+	gs.mu.Lock()
+	gs.Turn = ts
+	gs.mu.Unlock()
+
+	fmt.Println()
+	switch {
+	case ts.Turn == 0:
+		fmt.Println("==== Turns Off ====")
+		fmt.Println("Spawns and moves happen as soon as the server gets them.")
+	case ts.Phase == routing.TurnPhaseResolving:
+		fmt.Printf("==== Turn %d Over ====\n", ts.Turn)
+		fmt.Println("Carrying out everyone's orders...")
+	default:
+		fmt.Printf("==== Turn %d ====\n", ts.Turn)
+		if ts.Deadline.IsZero() {
+			fmt.Println("Send your orders, the turn ends when the server ends it.")
+		} else {
+			fmt.Printf("Send your orders before %s (%v left).\n", ts.Deadline.Format(time.TimeOnly), time.Until(ts.Deadline).Round(time.Second))
+		}
+	}
+}
+
+func (gs *GameState) getTurn() routing.TurnState {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.Turn
+}
+
+// checkTurn refuses orders once the game is over or while the server is
+// carrying out a turn.
+func (gs *GameState) checkTurn() error {
+	if gs.IsOver() {
+		return errors.New("the game is over")
+	}
+	turn := gs.getTurn()
+	if turn.Turn > 0 && turn.Phase != routing.TurnPhaseOrders {
+		return fmt.Errorf("turn %d is being carried out, wait for the next one", turn.Turn)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package logstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Query selects stored entries. Zero fields don't filter anything.
+type Query struct {
+	Username string
+	From     time.Time
+	To       time.Time
+	// Contains matches the message case-insensitively.
+	Contains string
+	// Limit keeps only the newest entries that match.
+	Limit int
+}
+
+func (q Query) Matches(e Entry) bool {
+	if q.Username != "" && e.Username != q.Username {
+		return false
+	}
+	if !q.From.IsZero() && e.Time.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && e.Time.After(q.To) {
+		return false
+	}
+	if q.Contains != "" && !strings.Contains(strings.ToLower(e.Message), strings.ToLower(q.Contains)) {
+		return false
+	}
+	return true
+}
+
+// Query reads every file, oldest first, and returns the matching entries in
+// the order they were written. Rotated files that were last written before
+// q.From are skipped without reading them.
+func (s *Store) Query(q Query) ([]Entry, error) {
+	s.mu.Lock()
+	rotated, err := s.rotatedFiles()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	paths := append(rotated, filepath.Join(s.options.Dir, currentFile))
+
+	matches := []Entry{}
+	for _, path := range paths {
+		if !q.From.IsZero() {
+			info, err := os.Stat(path)
+			if err == nil && info.ModTime().Before(q.From) {
+				continue
+			}
+		}
+
+		err := scanFile(path, func(e Entry) {
+			if !q.Matches(e) {
+				return
+			}
+			matches = append(matches, e)
+			if q.Limit > 0 && len(matches) > 2*q.Limit {
+				matches = append(matches[:0], matches[len(matches)-q.Limit:]...)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[len(matches)-q.Limit:]
+	}
+	return matches, nil
+}
+
+// scanFile calls found for every entry in a file. Lines that aren't entries,
+// like one cut short by a crash, are skipped. A file that was rotated away or
+// pruned in the meantime counts as empty.
+func scanFile(path string, found func(Entry)) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		found(e)
+	}
+	return scanner.Err()
+}
+
+// Follower receives entries as they are written, like tail -f.
+type Follower struct {
+	query   Query
+	entries chan Entry
+
+	mu      sync.Mutex
+	closed  bool
+	dropped int64
+}
+
+// Follow starts sending matching entries written from now on. Entries are
+// dropped rather than slowing down writes if the follower falls behind.
+func (s *Store) Follow(q Query) *Follower {
+	f := &Follower{query: q, entries: make(chan Entry, 256)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.followers[f] = struct{}{}
+
+	return f
+}
+
+// Unfollow stops the follower and closes its channel.
+func (s *Store) Unfollow(f *Follower) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.followers[f]; !ok {
+		return
+	}
+	delete(s.followers, f)
+	f.stop()
+}
+
+func (f *Follower) Entries() <-chan Entry {
+	return f.entries
+}
+
+// Dropped is how many matching entries were skipped because the follower
+// wasn't reading fast enough.
+func (f *Follower) Dropped() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.dropped
+}
+
+func (f *Follower) send(entries []Entry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return
+	}
+	for _, e := range entries {
+		if !f.query.Matches(e) {
+			continue
+		}
+		select {
+		case f.entries <- e:
+		default:
+			f.dropped++
+		}
+	}
+}
+
+func (f *Follower) stop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return
+	}
+	f.closed = true
+	close(f.entries)
+}
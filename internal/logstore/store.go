@@ -0,0 +1,306 @@
+// Package logstore keeps game logs as JSON lines in a directory of files
+// that are rotated by size and age and deleted once they are past their
+// retention.
+package logstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+const (
+	currentFile   = "game.jsonl"
+	rotatedPrefix = "game-"
+	rotatedSuffix = ".jsonl"
+	// rotatedLayout sorts rotated files by when they were started.
+	rotatedLayout = "20060102T150405.000000000"
+)
+
+type Options struct {
+	Dir string
+	// MaxFileSize and MaxFileAge rotate the current file once it is this big
+	// or its first entry is this old. Zero means no limit.
+	MaxFileSize int64
+	MaxFileAge  time.Duration
+	// Retention deletes rotated files whose last entry is older than this,
+	// MaxFiles deletes the oldest rotated files beyond this many. Zero keeps
+	// them forever.
+	Retention time.Duration
+	MaxFiles  int
+}
+
+var DefaultOptions = Options{
+	Dir:         "game_logs",
+	MaxFileSize: 10 << 20,
+	MaxFileAge:  24 * time.Hour,
+	Retention:   7 * 24 * time.Hour,
+	MaxFiles:    50,
+}
+
+// Entry is one game log as it is stored.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Username string    `json:"username"`
+	Message  string    `json:"message"`
+}
+
+func (e Entry) String() string {
+	return fmt.Sprintf("%v %v: %v", e.Time.Format(time.RFC3339), e.Username, e.Message)
+}
+
+// Store appends game logs in batches and only syncs once per batch, so a
+// batch is on disk when WriteBatch returns.
+type Store struct {
+	options Options
+
+	mu        sync.Mutex
+	file      *os.File
+	buf       *bufio.Writer
+	size      int64
+	started   time.Time
+	followers map[*Follower]struct{}
+	opened    time.Time
+	stats     Stats
+}
+
+// Stats counts what a Store wrote since it was opened.
+type Stats struct {
+	Entries   int64
+	Batches   int64
+	Bytes     int64
+	Failures  int64
+	Rotations int64
+	FlushTime time.Duration
+	Uptime    time.Duration
+}
+
+func Open(options Options) (*Store, error) {
+	err := os.MkdirAll(options.Dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("could not create log directory: %v", err)
+	}
+
+	s := &Store{
+		options:   options,
+		followers: map[*Follower]struct{}{},
+		opened:    time.Now(),
+	}
+	err = s.openCurrent()
+	if err != nil {
+		return nil, err
+	}
+	s.prune()
+
+	return s, nil
+}
+
+// openCurrent opens the file new entries go to. If it already has entries
+// its age counts from the first one.
+func (s *Store) openCurrent() error {
+	path := filepath.Join(s.options.Dir, currentFile)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open logs file: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("could not open logs file: %v", err)
+	}
+
+	s.file = f
+	s.buf = bufio.NewWriter(f)
+	s.size = info.Size()
+	s.started = time.Now()
+
+	scanner := bufio.NewScanner(f)
+	if s.size > 0 && scanner.Scan() {
+		var first Entry
+		if json.Unmarshal(scanner.Bytes(), &first) == nil {
+			s.started = first.Time
+		}
+	}
+	return nil
+}
+
+// WriteBatch writes every log and fsyncs the file. If it fails none of the
+// batch should be considered written, parts of it may be written again when
+// it is retried.
+func (s *Store) WriteBatch(gamelogs []routing.GameLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start := time.Now()
+	err := s.rotateIfNeeded(start)
+	if err != nil {
+		return s.fail(err)
+	}
+
+	entries := make([]Entry, 0, len(gamelogs))
+	written := 0
+	for _, gamelog := range gamelogs {
+		entry := Entry{Time: gamelog.CurrentTime, Username: gamelog.Username, Message: gamelog.Message}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return s.fail(fmt.Errorf("could not encode game log: %v", err))
+		}
+		n, err := s.buf.Write(append(line, '\n'))
+		written += n
+		if err != nil {
+			return s.fail(fmt.Errorf("could not write to logs file: %v", err))
+		}
+		entries = append(entries, entry)
+	}
+	err = s.buf.Flush()
+	if err != nil {
+		return s.fail(fmt.Errorf("could not write to logs file: %v", err))
+	}
+	err = s.file.Sync()
+	if err != nil {
+		return s.fail(fmt.Errorf("could not sync logs file: %v", err))
+	}
+
+	if s.size == 0 && len(entries) > 0 {
+		s.started = entries[0].Time
+	}
+	s.size += int64(written)
+	s.stats.Entries += int64(len(entries))
+	s.stats.Batches++
+	s.stats.Bytes += int64(written)
+	s.stats.FlushTime += time.Since(start)
+
+	for follower := range s.followers {
+		follower.send(entries)
+	}
+
+	return nil
+}
+
+// fail drops whatever is left in the buffer so a retry doesn't write it
+// twice.
+func (s *Store) fail(err error) error {
+	s.buf.Reset(s.file)
+	s.stats.Failures++
+	return err
+}
+
+func (s *Store) rotateIfNeeded(now time.Time) error {
+	if s.size == 0 {
+		return nil
+	}
+	tooBig := s.options.MaxFileSize > 0 && s.size >= s.options.MaxFileSize
+	tooOld := s.options.MaxFileAge > 0 && now.Sub(s.started) >= s.options.MaxFileAge
+	if !tooBig && !tooOld {
+		return nil
+	}
+
+	err := s.file.Close()
+	if err != nil {
+		return fmt.Errorf("could not close logs file: %v", err)
+	}
+	rotated := filepath.Join(s.options.Dir, rotatedPrefix+s.started.UTC().Format(rotatedLayout)+rotatedSuffix)
+	err = os.Rename(filepath.Join(s.options.Dir, currentFile), rotated)
+	if err != nil {
+		return fmt.Errorf("could not rotate logs file: %v", err)
+	}
+	s.stats.Rotations++
+
+	err = s.openCurrent()
+	if err != nil {
+		return err
+	}
+	s.prune()
+	return nil
+}
+
+// prune deletes rotated files that are past the retention or beyond
+// MaxFiles. Failing to delete one isn't worth failing a write over, it is
+// tried again on the next rotation.
+func (s *Store) prune() {
+	rotated, err := s.rotatedFiles()
+	if err != nil {
+		return
+	}
+
+	cutoff := time.Time{}
+	if s.options.Retention > 0 {
+		cutoff = time.Now().Add(-s.options.Retention)
+	}
+	keep := len(rotated)
+	if s.options.MaxFiles > 0 && keep > s.options.MaxFiles {
+		keep = s.options.MaxFiles
+	}
+
+	for i, path := range rotated {
+		expired := false
+		if !cutoff.IsZero() {
+			info, err := os.Stat(path)
+			expired = err == nil && info.ModTime().Before(cutoff)
+		}
+		if expired || i < len(rotated)-keep {
+			os.Remove(path)
+		}
+	}
+}
+
+// rotatedFiles lists rotated files, oldest first.
+func (s *Store) rotatedFiles() ([]string, error) {
+	entries, err := os.ReadDir(s.options.Dir)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, rotatedPrefix) && strings.HasSuffix(name, rotatedSuffix) {
+			paths = append(paths, filepath.Join(s.options.Dir, name))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (s *Store) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	stats.Uptime = time.Since(s.opened)
+	return stats
+}
+
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for follower := range s.followers {
+		follower.stop()
+	}
+	s.followers = map[*Follower]struct{}{}
+
+	err := s.buf.Flush()
+	return errors.Join(err, s.file.Close())
+}
+
+func (s Stats) String() string {
+	rate := 0.0
+	if s.Uptime > 0 {
+		rate = float64(s.Entries) / s.Uptime.Seconds()
+	}
+	avgBatch, avgFlush := 0.0, time.Duration(0)
+	if s.Batches > 0 {
+		avgBatch = float64(s.Entries) / float64(s.Batches)
+		avgFlush = s.FlushTime / time.Duration(s.Batches)
+	}
+	return fmt.Sprintf("%d logs in %d batches (%.1f per batch), %d bytes, %.1f logs/s, %v per flush, %d failed batches, %d rotations",
+		s.Entries, s.Batches, avgBatch, s.Bytes, rate, avgFlush.Round(time.Microsecond), s.Failures, s.Rotations)
+}
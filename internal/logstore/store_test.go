@@ -0,0 +1,201 @@
+package logstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+var start = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func gamelog(minute int, username, message string) routing.GameLog {
+	return routing.GameLog{CurrentTime: start.Add(time.Duration(minute) * time.Minute), Username: username, Message: message}
+}
+
+func write(t *testing.T, s *Store, gamelogs ...routing.GameLog) {
+	t.Helper()
+	if err := s.WriteBatch(gamelogs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func messages(t *testing.T, s *Store, q Query) []string {
+	t.Helper()
+	entries, err := s.Query(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := []string{}
+	for _, e := range entries {
+		found = append(found, e.Message)
+	}
+	return found
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRotateBySize(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(Options{Dir: dir, MaxFileSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	write(t, s, gamelog(0, "alice", "one"), gamelog(0, "bob", "two"))
+	write(t, s, gamelog(1, "alice", "three"))
+	write(t, s, gamelog(2, "alice", "four"))
+
+	rotated, err := s.rotatedFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 2 || s.Stats().Rotations != 2 {
+		t.Errorf("%d rotated files after %d rotations, want 2", len(rotated), s.Stats().Rotations)
+	}
+	if got := messages(t, s, Query{}); !equal(got, []string{"one", "two", "three", "four"}) {
+		t.Errorf("read back %v", got)
+	}
+	if stats := s.Stats(); stats.Entries != 4 || stats.Batches != 3 || stats.Failures != 0 {
+		t.Errorf("stats: %s", stats)
+	}
+}
+
+func TestRotateByAge(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(Options{Dir: dir, MaxFileAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, s, routing.GameLog{CurrentTime: time.Now().Add(-30 * time.Minute), Username: "alice", Message: "recent"})
+	write(t, s, gamelog(0, "alice", "more"))
+	if s.Stats().Rotations != 0 {
+		t.Fatal("a file half an hour old was rotated")
+	}
+	s.Close()
+
+	// Reopening counts the age from the first entry in the file, which is
+	// older than the new limit.
+	s, err = Open(Options{Dir: dir, MaxFileAge: 20 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	write(t, s, gamelog(1, "alice", "after"))
+	if s.Stats().Rotations != 1 {
+		t.Errorf("an old file wasn't rotated on reopening")
+	}
+	if got := messages(t, s, Query{}); !equal(got, []string{"recent", "more", "after"}) {
+		t.Errorf("read back %v", got)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(Options{Dir: dir, MaxFileSize: 1, MaxFiles: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, message := range []string{"one", "two", "three", "four", "five"} {
+		write(t, s, gamelog(i, "alice", message))
+	}
+	if got := messages(t, s, Query{}); !equal(got, []string{"three", "four", "five"}) {
+		t.Errorf("kept %v, want the two newest rotated files and the current one", got)
+	}
+	s.Close()
+
+	rotated, err := s.rotatedFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(rotated[0], old, old); err != nil {
+		t.Fatal(err)
+	}
+	s, err = Open(Options{Dir: dir, Retention: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := messages(t, s, Query{}); !equal(got, []string{"four", "five"}) {
+		t.Errorf("kept %v after the oldest file passed the retention", got)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(Options{Dir: dir, MaxFileSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	write(t, s,
+		gamelog(0, "alice", "Spawned infantry"),
+		gamelog(1, "bob", "spawned cavalry"),
+		gamelog(2, "alice", "moved to asia"),
+	)
+	write(t, s,
+		gamelog(3, "bob", "declared war"),
+		gamelog(4, "alice", "won the war"),
+	)
+
+	// A line cut short by a crash is skipped.
+	f, err := os.OpenFile(filepath.Join(dir, currentFile), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"time":"2024-01-01T12:05:00Z","username":"al`)
+	f.Close()
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"everything", Query{}, []string{"Spawned infantry", "spawned cavalry", "moved to asia", "declared war", "won the war"}},
+		{"username", Query{Username: "bob"}, []string{"spawned cavalry", "declared war"}},
+		{"contains", Query{Contains: "SPAWNED"}, []string{"Spawned infantry", "spawned cavalry"}},
+		{"time", Query{From: start.Add(time.Minute), To: start.Add(3 * time.Minute)}, []string{"spawned cavalry", "moved to asia", "declared war"}},
+		{"limit", Query{Limit: 2}, []string{"declared war", "won the war"}},
+		{"combined", Query{Username: "alice", Contains: "war", Limit: 5}, []string{"won the war"}},
+	}
+	for _, tt := range tests {
+		if got := messages(t, s, tt.query); !equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFollow(t *testing.T) {
+	s, err := Open(Options{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	write(t, s, gamelog(0, "bob", "before following"))
+	f := s.Follow(Query{Username: "bob"})
+	write(t, s, gamelog(1, "alice", "not for the follower"), gamelog(2, "bob", "followed"))
+	s.Unfollow(f)
+
+	got := []string{}
+	for e := range f.Entries() {
+		got = append(got, e.Message)
+	}
+	if !equal(got, []string{"followed"}) || f.Dropped() != 0 {
+		t.Errorf("followed %v and dropped %d", got, f.Dropped())
+	}
+}
@@ -0,0 +1,153 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type AMQPBroker struct {
+	conn    *amqp.Connection
+	channel *amqp.Channel
+}
+
+func DialAMQP(url string) (*AMQPBroker, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+
+	broker, err := NewAMQPBroker(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return broker, nil
+}
+
+func NewAMQPBroker(conn *amqp.Connection) (*AMQPBroker, error) {
+	channel, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	return &AMQPBroker{conn: conn, channel: channel}, nil
+}
+
+func (b *AMQPBroker) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	return b.channel.PublishWithContext(ctx, exchange, key, false, false, msg)
+}
+
+func (b *AMQPBroker) DeclareExchange(name, kind string) error {
+	return b.channel.ExchangeDeclare(name, kind, true, false, false, false, nil)
+}
+
+func (b *AMQPBroker) DeclareAndBindQueue(exchange, queueName, key string, options QueueOptions) error {
+	return declareAndBindQueue(b.conn, exchange, queueName, key, options)
+}
+
+func (b *AMQPBroker) DeclareQueue(queueName string, options QueueOptions) error {
+	return declareQueue(b.conn, queueName, options)
+}
+
+func (b *AMQPBroker) Consume(queueName string, prefetch int) (Consumer, error) {
+	return consume(b.conn, queueName, prefetch)
+}
+
+func (b *AMQPBroker) Channel() (*amqp.Channel, error) {
+	return b.conn.Channel()
+}
+
+func (b *AMQPBroker) Close() error {
+	return b.conn.Close()
+}
+
+func declareAndBindQueue(conn *amqp.Connection, exchange, queueName, key string, options QueueOptions) error {
+	args, err := options.arguments()
+	if err != nil {
+		return err
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	durable, autoDelete, exclusive := options.flags()
+	_, err = channel.QueueDeclare(queueName, durable, autoDelete, exclusive, false, args)
+	if err != nil {
+		return err
+	}
+
+	err = channel.QueueBind(queueName, key, exchange, false, nil)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func declareQueue(conn *amqp.Connection, queueName string, options QueueOptions) error {
+	args, err := options.arguments()
+	if err != nil {
+		return err
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	durable, autoDelete, exclusive := options.flags()
+	_, err = channel.QueueDeclare(queueName, durable, autoDelete, exclusive, false, args)
+	return err
+}
+
+// consume opens a dedicated channel for a consumer so its prefetch limit
+// doesn't affect anyone else.
+func consume(conn *amqp.Connection, queueName string, prefetch int) (*amqpConsumer, error) {
+	channel, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	err = channel.Qos(prefetch, 0, false)
+	if err != nil {
+		channel.Close()
+		return nil, err
+	}
+
+	tag := fmt.Sprintf("peril-%s-%d", queueName, consumerSeq.Add(1))
+	deliveries, err := channel.Consume(queueName, tag, false, false, false, false, nil)
+	if err != nil {
+		channel.Close()
+		return nil, err
+	}
+
+	return &amqpConsumer{channel: channel, tag: tag, deliveries: deliveries}, nil
+}
+
+var consumerSeq atomic.Uint64
+
+type amqpConsumer struct {
+	channel    *amqp.Channel
+	tag        string
+	deliveries <-chan amqp.Delivery
+}
+
+func (c *amqpConsumer) Deliveries() <-chan amqp.Delivery {
+	return c.deliveries
+}
+
+func (c *amqpConsumer) Cancel() error {
+	return c.channel.Cancel(c.tag, false)
+}
+
+func (c *amqpConsumer) Close() error {
+	return c.channel.Close()
+}
@@ -0,0 +1,146 @@
+package pubsub
+
+import (
+	"errors"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// BatchOptions says when SubscribeBatch hands the collected messages to the
+// handler: once Size of them arrived, or Interval after the first one,
+// whichever comes first.
+type BatchOptions struct {
+	Size     int
+	Interval time.Duration
+}
+
+// SubscribeBatch is Subscribe for handlers that work on many messages at
+// once, like writing them to disk. The whole batch is acknowledged with
+// multiple-acks after the handler returns, so nothing is acked before the
+// handler is done with it. WithWorkers and WithOrderKey don't apply, batches
+// are handled one at a time in the order they arrived.
+func SubscribeBatch[T any](sub Subscriber, exchange, queueName, key string, queueOptions QueueOptions, batch BatchOptions, handler func([]T) AckType, opts ...SubscribeOption) (*Subscription, error) {
+	if batch.Size < 1 {
+		return nil, errors.New("batch size must be at least 1")
+	}
+	if batch.Interval <= 0 {
+		return nil, errors.New("batch interval must be positive")
+	}
+
+	options := newSubscribeOptions(sub, opts)
+
+	err := declareSubscription(sub, exchange, queueName, key, queueOptions, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// The broker has to send at least a whole batch without waiting for acks,
+	// or batches would only ever be flushed by the interval.
+	prefetch := options.prefetch
+	if prefetch <= 0 {
+		prefetch = 2 * batch.Size
+	}
+	consumer, err := sub.Consume(queueName, prefetch)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Subscription{
+		queueName: queueName,
+		consumer:  consumer,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.done)
+
+		msgs := make([]T, 0, batch.Size)
+		deliveries := make([]amqp.Delivery, 0, batch.Size)
+		timer := time.NewTimer(batch.Interval)
+		// Before go 1.23 a timer that fired but wasn't received from keeps
+		// its tick after Stop and Reset, which would flush the next batch
+		// too early.
+		stopTimer := func() {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		}
+		stopTimer()
+
+		flush := func() {
+			stopTimer()
+			if len(msgs) == 0 {
+				return
+			}
+			options.settleBatch(queueName, deliveries, handler(msgs))
+			msgs = make([]T, 0, batch.Size)
+			deliveries = make([]amqp.Delivery, 0, batch.Size)
+		}
+
+		incoming := consumer.Deliveries()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-timer.C:
+				flush()
+			case delivery, ok := <-incoming:
+				if !ok {
+					flush()
+					return
+				}
+				msg, err := decode[T](delivery, options)
+				if err != nil {
+					options.poison(queueName, delivery, err)
+					continue
+				}
+				msgs = append(msgs, msg)
+				deliveries = append(deliveries, delivery)
+				if len(msgs) == 1 {
+					timer.Reset(batch.Interval)
+				}
+				if len(msgs) >= batch.Size {
+					flush()
+				}
+			}
+		}
+	}()
+
+	return s, nil
+}
+
+// settleBatch acknowledges a handled batch. Deliveries are settled with one
+// multiple-ack per channel they came in on, a batch can span channels when
+// the consumer reconnected in the middle of it.
+func (o *subscribeOptions) settleBatch(queueName string, deliveries []amqp.Delivery, ack AckType) {
+	if ack == NackRequeue && o.retry != nil && o.publisher != nil {
+		for _, delivery := range deliveries {
+			o.retryLater(queueName, delivery)
+		}
+		return
+	}
+
+	for i, delivery := range deliveries {
+		if i+1 < len(deliveries) && deliveries[i+1].Acknowledger == delivery.Acknowledger {
+			continue
+		}
+
+		var err error
+		switch ack {
+		case Ack:
+			err = delivery.Ack(true)
+		case NackRequeue:
+			err = delivery.Nack(true, true)
+		case NackDiscard:
+			err = delivery.Nack(true, false)
+		}
+		if err != nil {
+			o.report(&SubscriptionError{Queue: queueName, Op: "ack", Err: err})
+		}
+	}
+}
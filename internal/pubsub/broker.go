@@ -0,0 +1,58 @@
+package pubsub
+
+import (
+	"context"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Publisher sends raw messages to an exchange.
+type Publisher interface {
+	Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error
+}
+
+// AsUser sets the user_id of everything published through pub. RabbitMQ
+// refuses messages whose user_id isn't the user the connection logged in
+// as, so consumers can check it with WithVerify to know who sent them.
+func AsUser(pub Publisher, userID string) Publisher {
+	return userPublisher{pub: pub, userID: userID}
+}
+
+type userPublisher struct {
+	pub    Publisher
+	userID string
+}
+
+func (p userPublisher) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	msg.UserId = p.userID
+	return p.pub.Publish(ctx, exchange, key, msg)
+}
+
+// Subscriber declares queues and consumes deliveries from them.
+type Subscriber interface {
+	DeclareAndBindQueue(exchange, queueName, key string, options QueueOptions) error
+	// DeclareQueue declares a queue that isn't bound to any exchange, like
+	// the retry queues, which are only ever reached through the default
+	// exchange.
+	DeclareQueue(queueName string, options QueueOptions) error
+	Consume(queueName string, prefetch int) (Consumer, error)
+}
+
+// Consumer is one running consumer on a queue.
+type Consumer interface {
+	Deliveries() <-chan amqp.Delivery
+	// Cancel stops new deliveries. Ones already received can still be
+	// acknowledged and the deliveries channel closes once they're all out.
+	Cancel() error
+	// Close releases the consumer, anything left unacknowledged is requeued.
+	Close() error
+}
+
+// Broker is everything the server and clients need from a message broker.
+// AMQPBroker talks to RabbitMQ, MemoryBroker runs in-process.
+type Broker interface {
+	Publisher
+	Subscriber
+	DeclareExchange(name, kind string) error
+	Close() error
+}
@@ -0,0 +1,100 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Codec turns messages into bodies and back for one content type.
+type Codec interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) ContentType() string {
+	return "application/gob"
+}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buff bytes.Buffer
+	encoder := gob.NewEncoder(&buff)
+	err := encoder.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	decoder := gob.NewDecoder(bytes.NewBuffer(data))
+	return decoder.Decode(v)
+}
+
+var (
+	JSONCodec     Codec = jsonCodec{}
+	GobCodec      Codec = gobCodec{}
+	MsgpackCodec  Codec = msgpackCodec{}
+	ProtobufCodec Codec = protobufCodec{}
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		JSONCodec.ContentType():     JSONCodec,
+		GobCodec.ContentType():      GobCodec,
+		MsgpackCodec.ContentType():  MsgpackCodec,
+		ProtobufCodec.ContentType(): ProtobufCodec,
+	}
+)
+
+// RegisterCodec makes a codec available to Subscribe for its content type,
+// replacing any codec already registered for it.
+func RegisterCodec(codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[codec.ContentType()] = codec
+}
+
+func CodecFor(contentType string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, ok := codecs[contentType]
+	return codec, ok
+}
+
+// decoderFor picks the codec for a delivery, falling back to fallback when
+// the producer didn't set a content type.
+func decoderFor(contentType string, fallback Codec) (Codec, error) {
+	if contentType == "" {
+		if fallback == nil {
+			return nil, fmt.Errorf("message has no content type")
+		}
+		return fallback, nil
+	}
+
+	codec, ok := CodecFor(contentType)
+	if !ok {
+		return nil, fmt.Errorf("no codec registered for content type '%s'", contentType)
+	}
+	return codec, nil
+}
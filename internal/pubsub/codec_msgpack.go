@@ -0,0 +1,19 @@
+package pubsub
+
+import (
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string {
+	return "application/x-msgpack"
+}
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	return msgpack.Unmarshal(data, v)
+}
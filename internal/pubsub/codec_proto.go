@@ -0,0 +1,76 @@
+package pubsub
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+type protobufCodec struct{}
+
+// protoMapping converts a plain Go type to and from its protobuf message.
+type protoMapping struct {
+	toProto    func(any) proto.Message
+	newMessage func() proto.Message
+	fromProto  func(proto.Message, any)
+}
+
+var protoMappings sync.Map // reflect.Type -> protoMapping
+
+// RegisterProtoMapping lets ProtobufCodec carry T by converting it to the
+// generated message M. Types that already are proto messages need no mapping.
+func RegisterProtoMapping[T any, M proto.Message](to func(T) M, from func(M) T) {
+	messageType := reflect.TypeOf((*M)(nil)).Elem().Elem()
+	protoMappings.Store(reflect.TypeOf((*T)(nil)).Elem(), protoMapping{
+		toProto: func(v any) proto.Message {
+			return to(v.(T))
+		},
+		newMessage: func() proto.Message {
+			return reflect.New(messageType).Interface().(M)
+		},
+		fromProto: func(m proto.Message, v any) {
+			*v.(*T) = from(m.(M))
+		},
+	})
+}
+
+func (protobufCodec) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (protobufCodec) Marshal(v any) ([]byte, error) {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Marshal(m)
+	}
+
+	mapping, ok := protoMappings.Load(reflect.TypeOf(v))
+	if !ok {
+		return nil, fmt.Errorf("no protobuf mapping registered for %T", v)
+	}
+	return proto.Marshal(mapping.(protoMapping).toProto(v))
+}
+
+func (protobufCodec) Unmarshal(data []byte, v any) error {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+
+	t := reflect.TypeOf(v)
+	if t.Kind() != reflect.Pointer {
+		return fmt.Errorf("can't unmarshal protobuf into non-pointer %T", v)
+	}
+	mapping, ok := protoMappings.Load(t.Elem())
+	if !ok {
+		return fmt.Errorf("no protobuf mapping registered for %v", t.Elem())
+	}
+
+	m := mapping.(protoMapping).newMessage()
+	err := proto.Unmarshal(data, m)
+	if err != nil {
+		return err
+	}
+	mapping.(protoMapping).fromProto(m, v)
+	return nil
+}
@@ -0,0 +1,297 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// publishSeqHeader carries the confirm sequence number so returned messages
+// can be matched with the publish they came from.
+const publishSeqHeader = "x-publish-seq"
+
+var ErrNacked = errors.New("broker refused the message")
+
+// UnroutableError is returned when a mandatory publish matched no queue.
+type UnroutableError struct {
+	Exchange  string
+	Key       string
+	ReplyCode uint16
+	ReplyText string
+}
+
+func (e *UnroutableError) Error() string {
+	return fmt.Sprintf("message to '%s' with key '%s' was not routed to any queue: %s", e.Exchange, e.Key, e.ReplyText)
+}
+
+// ChannelOpener is anything that can open AMQP channels. *amqp.Connection,
+// AMQPBroker and ReconnectingBroker all qualify.
+type ChannelOpener interface {
+	Channel() (*amqp.Channel, error)
+}
+
+// ConfirmedPublisher publishes with mandatory routing on a channel in
+// confirm mode, so every publish reports whether the broker actually
+// accepted and routed the message.
+type ConfirmedPublisher struct {
+	opener  ChannelOpener
+	timeout time.Duration
+
+	mu      sync.Mutex
+	current *confirmChannel
+	closed  bool
+}
+
+// confirmChannel tracks the unconfirmed publishes of one AMQP channel. It has
+// its own lock so confirmations never wait on a publish in progress.
+type confirmChannel struct {
+	channel *amqp.Channel
+	nextSeq uint64
+
+	mu      sync.Mutex
+	pending map[uint64]*Confirmation
+	dead    bool
+}
+
+// Confirmation is the future result of an asynchronous publish.
+type Confirmation struct {
+	exchange string
+	key      string
+	channel  *confirmChannel
+	seq      uint64
+	done     chan struct{}
+	err      error
+}
+
+func NewConfirmedPublisher(opener ChannelOpener, timeout time.Duration) (*ConfirmedPublisher, error) {
+	p := &ConfirmedPublisher{
+		opener:  opener,
+		timeout: timeout,
+	}
+
+	current, err := p.open()
+	if err != nil {
+		return nil, err
+	}
+	p.current = current
+
+	return p, nil
+}
+
+// Publish publishes and waits for the broker's verdict. If ctx has no
+// deadline the publisher's timeout is used.
+func (p *ConfirmedPublisher) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	if _, ok := ctx.Deadline(); !ok && p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	confirmation, err := p.PublishAsync(ctx, exchange, key, msg)
+	if err != nil {
+		return err
+	}
+
+	return confirmation.Wait(ctx)
+}
+
+func (p *ConfirmedPublisher) PublishAsync(ctx context.Context, exchange, key string, msg amqp.Publishing) (*Confirmation, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrBrokerClosed
+	}
+	if p.current == nil || p.current.isDead() {
+		current, err := p.open()
+		if err != nil {
+			return nil, err
+		}
+		p.current = current
+	}
+	cc := p.current
+
+	cc.nextSeq++
+	seq := cc.nextSeq
+
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[publishSeqHeader] = int64(seq)
+	msg.Headers = headers
+
+	confirmation := &Confirmation{exchange: exchange, key: key, channel: cc, seq: seq, done: make(chan struct{})}
+	cc.mu.Lock()
+	cc.pending[seq] = confirmation
+	cc.mu.Unlock()
+
+	err := cc.channel.PublishWithContext(ctx, exchange, key, true, false, msg)
+	if err != nil {
+		cc.mu.Lock()
+		delete(cc.pending, seq)
+		cc.mu.Unlock()
+		// The channel doesn't count a publish it couldn't send either, the
+		// next one gets the same sequence number.
+		cc.nextSeq--
+		return nil, err
+	}
+
+	return confirmation, nil
+}
+
+func (p *ConfirmedPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+
+	if p.current != nil {
+		return p.current.channel.Close()
+	}
+	return nil
+}
+
+func (p *ConfirmedPublisher) open() (*confirmChannel, error) {
+	channel, err := p.opener.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	err = channel.Confirm(false)
+	if err != nil {
+		channel.Close()
+		return nil, err
+	}
+
+	cc := &confirmChannel{channel: channel, pending: map[uint64]*Confirmation{}}
+
+	// Both notifications are handled by one goroutine: the AMQP library sends
+	// a message's return before its ack, so the return is always recorded
+	// before the confirmation resolves.
+	go cc.dispatch(
+		channel.NotifyPublish(make(chan amqp.Confirmation)),
+		channel.NotifyReturn(make(chan amqp.Return)),
+		channel.NotifyClose(make(chan *amqp.Error, 1)),
+	)
+
+	return cc, nil
+}
+
+func (cc *confirmChannel) dispatch(confirms <-chan amqp.Confirmation, returns <-chan amqp.Return, closed <-chan *amqp.Error) {
+	returned := map[uint64]*UnroutableError{}
+
+	for {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			seq, ok := ret.Headers[publishSeqHeader].(int64)
+			if !ok {
+				continue
+			}
+			returned[uint64(seq)] = &UnroutableError{
+				Exchange:  ret.Exchange,
+				Key:       ret.RoutingKey,
+				ReplyCode: ret.ReplyCode,
+				ReplyText: ret.ReplyText,
+			}
+		case confirm, ok := <-confirms:
+			if !ok {
+				confirms = nil
+				continue
+			}
+			var err error
+			if unroutable, ok := returned[confirm.DeliveryTag]; ok {
+				err = unroutable
+				delete(returned, confirm.DeliveryTag)
+			} else if !confirm.Ack {
+				err = ErrNacked
+			}
+			cc.resolve(confirm.DeliveryTag, err)
+		case reason := <-closed:
+			err := error(amqp.ErrClosed)
+			if reason != nil {
+				err = reason
+			}
+			cc.failAll(err)
+			return
+		}
+	}
+}
+
+func (cc *confirmChannel) resolve(seq uint64, err error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	confirmation, ok := cc.pending[seq]
+	if !ok {
+		return
+	}
+	delete(cc.pending, seq)
+	confirmation.resolve(err)
+}
+
+// failAll resolves everything still waiting on a channel that died. The
+// next publish opens a fresh channel.
+func (cc *confirmChannel) failAll(err error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	for seq, confirmation := range cc.pending {
+		confirmation.resolve(fmt.Errorf("channel closed before confirm: %w", err))
+		delete(cc.pending, seq)
+	}
+	cc.dead = true
+}
+
+func (cc *confirmChannel) isDead() bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.dead
+}
+
+func (c *Confirmation) resolve(err error) {
+	c.err = err
+	close(c.done)
+}
+
+// Done is closed once the broker has acked, nacked or returned the message.
+func (c *Confirmation) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err is the publish result. It is only meaningful after Done is closed.
+func (c *Confirmation) Err() error {
+	return c.err
+}
+
+// Wait returns the publish result, or an error once ctx ends. A message
+// that timed out is no longer tracked: the confirmation resolves with the
+// same error and a later ack from the broker is ignored.
+func (c *Confirmation) Wait(ctx context.Context) error {
+	select {
+	case <-c.done:
+		return c.err
+	case <-ctx.Done():
+		err := fmt.Errorf("waiting for confirm of message to '%s' with key '%s': %w", c.exchange, c.key, ctx.Err())
+		c.channel.resolve(c.seq, err)
+		<-c.done
+		return c.err
+	}
+}
+
+// Then calls callback with the publish result once it is known.
+func (c *Confirmation) Then(callback func(error)) {
+	go func() {
+		<-c.done
+		callback(c.err)
+	}()
+}
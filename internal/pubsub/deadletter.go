@@ -0,0 +1,86 @@
+package pubsub
+
+import (
+	"strings"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DeadLetter describes why and from where a message in peril_dlq was
+// dead-lettered, whether RabbitMQ did it or a subscriber did.
+type DeadLetter struct {
+	Exchange   string
+	RoutingKey string
+	Queue      string
+	Reason     string
+	// Deaths are RabbitMQ's x-death entries, most recent first.
+	Deaths []amqp.Table
+}
+
+func InspectDeadLetter(delivery amqp.Delivery) DeadLetter {
+	dl := DeadLetter{RoutingKey: delivery.RoutingKey}
+	for _, d := range tableSlice(delivery.Headers["x-death"]) {
+		if death, ok := d.(amqp.Table); ok {
+			dl.Deaths = append(dl.Deaths, death)
+		}
+	}
+
+	dl.Reason, _ = delivery.Headers["x-first-death-reason"].(string)
+	dl.Queue, _ = delivery.Headers["x-first-death-queue"].(string)
+	dl.Exchange, _ = delivery.Headers["x-first-death-exchange"].(string)
+	for _, death := range dl.Deaths {
+		if death["queue"] != dl.Queue {
+			continue
+		}
+		if keys := tableSlice(death["routing-keys"]); len(keys) > 0 {
+			dl.RoutingKey, _ = keys[0].(string)
+		}
+	}
+
+	if reason, ok := delivery.Headers[DeadLetterReasonHeader].(string); ok {
+		dl.Reason = reason
+	}
+	if exchange, ok := delivery.Headers[OriginalExchangeHeader].(string); ok {
+		dl.Exchange = exchange
+	}
+	if key, ok := delivery.Headers[OriginalRoutingKeyHeader].(string); ok {
+		dl.RoutingKey = key
+	}
+
+	return dl
+}
+
+// Replay turns a dead-lettered delivery back into a fresh publishing,
+// dropping the dead-letter and retry bookkeeping headers. It keeps the
+// user_id the message was first published with, so only a user with
+// RabbitMQ's impersonator tag can publish it.
+func Replay(delivery amqp.Delivery) amqp.Publishing {
+	headers := amqp.Table{}
+	for k, v := range delivery.Headers {
+		if k == "x-death" || strings.HasPrefix(k, "x-first-death-") {
+			continue
+		}
+		switch k {
+		case DecodeErrorHeader, VerifyErrorHeader, DeadLetterReasonHeader, RetryAttemptHeader, RetryFailureHeader, OriginalExchangeHeader, OriginalRoutingKeyHeader, OriginalUserIDHeader:
+			continue
+		}
+		headers[k] = v
+	}
+	publishing := republishing(delivery, headers)
+	publishing.UserId = delivery.UserId
+	if userID, ok := delivery.Headers[OriginalUserIDHeader].(string); ok {
+		publishing.UserId = userID
+	}
+	return publishing
+}
+
+// RoutingKeyMatches reports whether key matches a topic binding pattern,
+// where "*" matches one word and "#" matches any number of them.
+func RoutingKeyMatches(pattern, key string) bool {
+	return topicMatches(strings.Split(pattern, "."), strings.Split(key, "."))
+}
+
+func tableSlice(v interface{}) []interface{} {
+	s, _ := v.([]interface{})
+	return s
+}
@@ -0,0 +1,593 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var ErrBrokerClosed = errors.New("broker is closed")
+
+// MemoryBroker is an in-process Broker with the same routing, acking and
+// dead-lettering semantics as RabbitMQ, so games can run without a server.
+type MemoryBroker struct {
+	mu        sync.Mutex
+	exchanges map[string]*memoryExchange
+	queues    map[string]*memoryQueue
+	closed    bool
+}
+
+type memoryExchange struct {
+	kind     string
+	bindings []memoryBinding
+}
+
+type memoryBinding struct {
+	queue string
+	key   string
+}
+
+type memoryQueue struct {
+	broker    *MemoryBroker
+	name      string
+	args      amqp.Table
+	messages  []memoryMessage
+	consumers []*memoryConsumer
+	cond      *sync.Cond
+}
+
+type memoryMessage struct {
+	exchange    string
+	routingKey  string
+	publishing  amqp.Publishing
+	redelivered bool
+	expires     time.Time
+}
+
+type memoryConsumer struct {
+	broker     *MemoryBroker
+	queue      *memoryQueue
+	tag        string
+	prefetch   int
+	deliveries chan amqp.Delivery
+	unacked    map[uint64]memoryMessage
+	nextTag    uint64
+	cancelled  bool
+	done       chan struct{}
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		exchanges: map[string]*memoryExchange{},
+		queues:    map[string]*memoryQueue{},
+	}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrBrokerClosed
+	}
+
+	return b.route(memoryMessage{exchange: exchange, routingKey: key, publishing: msg})
+}
+
+func (b *MemoryBroker) DeclareExchange(name, kind string) error {
+	switch kind {
+	case amqp.ExchangeDirect, amqp.ExchangeTopic, amqp.ExchangeFanout:
+	default:
+		return fmt.Errorf("unsupported exchange kind %q", kind)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrBrokerClosed
+	}
+
+	if ex, ok := b.exchanges[name]; ok {
+		if ex.kind != kind {
+			return fmt.Errorf("exchange %q already declared as %s", name, ex.kind)
+		}
+		return nil
+	}
+	b.exchanges[name] = &memoryExchange{kind: kind}
+
+	return nil
+}
+
+func (b *MemoryBroker) DeclareAndBindQueue(exchange, queueName, key string, options QueueOptions) error {
+	args, err := options.arguments()
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrBrokerClosed
+	}
+
+	ex, ok := b.exchanges[exchange]
+	if !ok {
+		return fmt.Errorf("no exchange %q", exchange)
+	}
+
+	b.declareQueue(queueName, args)
+
+	for _, binding := range ex.bindings {
+		if binding.queue == queueName && binding.key == key {
+			return nil
+		}
+	}
+	ex.bindings = append(ex.bindings, memoryBinding{queue: queueName, key: key})
+
+	return nil
+}
+
+func (b *MemoryBroker) DeclareQueue(queueName string, options QueueOptions) error {
+	args, err := options.arguments()
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrBrokerClosed
+	}
+
+	b.declareQueue(queueName, args)
+	return nil
+}
+
+// declareQueue must be called with b.mu held.
+func (b *MemoryBroker) declareQueue(queueName string, args amqp.Table) {
+	if _, ok := b.queues[queueName]; ok {
+		return
+	}
+
+	queue := &memoryQueue{
+		broker: b,
+		name:   queueName,
+		args:   amqp.Table{},
+	}
+	for k, v := range args {
+		queue.args[k] = v
+	}
+	queue.cond = sync.NewCond(&b.mu)
+	b.queues[queueName] = queue
+}
+
+func (b *MemoryBroker) Consume(queueName string, prefetch int) (Consumer, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrBrokerClosed
+	}
+
+	queue, ok := b.queues[queueName]
+	if !ok {
+		return nil, fmt.Errorf("no queue %q", queueName)
+	}
+
+	consumer := &memoryConsumer{
+		broker:     b,
+		queue:      queue,
+		tag:        fmt.Sprintf("memory-%s-%d", queueName, consumerSeq.Add(1)),
+		prefetch:   prefetch,
+		deliveries: make(chan amqp.Delivery),
+		unacked:    map[uint64]memoryMessage{},
+		done:       make(chan struct{}),
+	}
+	queue.consumers = append(queue.consumers, consumer)
+	go consumer.run()
+
+	return consumer, nil
+}
+
+func (b *MemoryBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+
+	for _, queue := range b.queues {
+		for len(queue.consumers) > 0 {
+			queue.consumers[0].cancel()
+		}
+	}
+
+	return nil
+}
+
+// QueueLength reports how many messages are waiting in a queue, not counting
+// ones delivered but not yet acknowledged.
+func (b *MemoryBroker) QueueLength(queueName string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queue, ok := b.queues[queueName]
+	if !ok {
+		return 0
+	}
+	return len(queue.messages)
+}
+
+// route must be called with b.mu held.
+func (b *MemoryBroker) route(msg memoryMessage) error {
+	// The default exchange routes straight to the queue named by the key.
+	if msg.exchange == "" {
+		if queue, ok := b.queues[msg.routingKey]; ok {
+			queue.push(msg)
+		}
+		return nil
+	}
+
+	ex, ok := b.exchanges[msg.exchange]
+	if !ok {
+		return fmt.Errorf("no exchange %q", msg.exchange)
+	}
+
+	routed := map[string]struct{}{}
+	for _, binding := range ex.bindings {
+		if _, ok := routed[binding.queue]; ok {
+			continue
+		}
+		if !bindingMatches(ex.kind, binding.key, msg.routingKey) {
+			continue
+		}
+		queue, ok := b.queues[binding.queue]
+		if !ok {
+			continue
+		}
+		routed[binding.queue] = struct{}{}
+		queue.push(msg)
+	}
+
+	return nil
+}
+
+// deadLetter must be called with b.mu held.
+func (b *MemoryBroker) deadLetter(queue *memoryQueue, msg memoryMessage, reason string) {
+	dlx, ok := queue.args["x-dead-letter-exchange"].(string)
+	if !ok {
+		return
+	}
+	key := msg.routingKey
+	if dlKey, ok := queue.args["x-dead-letter-routing-key"].(string); ok {
+		key = dlKey
+	}
+
+	headers := amqp.Table{}
+	for k, v := range msg.publishing.Headers {
+		headers[k] = v
+	}
+	headers["x-death"] = appendDeath(headers["x-death"], amqp.Table{
+		"count":        int64(1),
+		"reason":       reason,
+		"queue":        queue.name,
+		"time":         time.Now(),
+		"exchange":     msg.exchange,
+		"routing-keys": []interface{}{msg.routingKey},
+	})
+	if _, ok := headers["x-first-death-reason"]; !ok {
+		headers["x-first-death-reason"] = reason
+		headers["x-first-death-queue"] = queue.name
+		headers["x-first-death-exchange"] = msg.exchange
+	}
+
+	publishing := msg.publishing
+	publishing.Headers = headers
+	b.route(memoryMessage{exchange: dlx, routingKey: key, publishing: publishing})
+}
+
+// appendDeath merges a new x-death entry into the existing list, bumping the
+// count of an entry for the same queue and reason like RabbitMQ does.
+func appendDeath(existing interface{}, death amqp.Table) []interface{} {
+	deaths, _ := existing.([]interface{})
+	for i, d := range deaths {
+		entry, ok := d.(amqp.Table)
+		if !ok || entry["queue"] != death["queue"] || entry["reason"] != death["reason"] {
+			continue
+		}
+		count, _ := entry["count"].(int64)
+		updated := amqp.Table{}
+		for k, v := range entry {
+			updated[k] = v
+		}
+		updated["count"] = count + 1
+		updated["time"] = death["time"]
+		rest := append([]interface{}{}, deaths[:i]...)
+		rest = append(rest, deaths[i+1:]...)
+		return append([]interface{}{updated}, rest...)
+	}
+	return append([]interface{}{death}, deaths...)
+}
+
+func bindingMatches(kind, bindingKey, routingKey string) bool {
+	switch kind {
+	case amqp.ExchangeFanout:
+		return true
+	case amqp.ExchangeDirect:
+		return bindingKey == routingKey
+	case amqp.ExchangeTopic:
+		return topicMatches(strings.Split(bindingKey, "."), strings.Split(routingKey, "."))
+	}
+	return false
+}
+
+// topicMatches implements AMQP topic matching where "*" matches exactly one
+// word and "#" matches zero or more words.
+func topicMatches(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if topicMatches(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && topicMatches(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && topicMatches(pattern[1:], words[1:])
+	}
+}
+
+// push must be called with the broker lock held.
+func (q *memoryQueue) push(msg memoryMessage) {
+	if ttl, ok := tableInt(q.args["x-message-ttl"]); ok && ttl >= 0 {
+		delay := time.Duration(ttl) * time.Millisecond
+		msg.expires = time.Now().Add(delay)
+		time.AfterFunc(delay, func() {
+			q.broker.mu.Lock()
+			defer q.broker.mu.Unlock()
+			q.expire()
+		})
+	}
+
+	if max, ok := tableInt(q.args["x-max-length"]); ok && int64(len(q.messages)) >= max && len(q.messages) > 0 {
+		switch q.args["x-overflow"] {
+		case string(OverflowRejectPublish):
+			return
+		case string(OverflowRejectPublishDLX):
+			q.broker.deadLetter(q, msg, "maxlen")
+			return
+		default:
+			head := q.messages[0]
+			q.messages = q.messages[1:]
+			q.broker.deadLetter(q, head, "maxlen")
+		}
+	}
+
+	q.messages = append(q.messages, msg)
+	q.cond.Broadcast()
+}
+
+// waiting reports whether c has to wait before it can take a message. It
+// must be called with the broker lock held.
+func (q *memoryQueue) waiting(c *memoryConsumer) bool {
+	if len(q.messages) == 0 {
+		return true
+	}
+	if c.prefetch > 0 && len(c.unacked) >= c.prefetch {
+		return true
+	}
+	if active, _ := q.args["x-single-active-consumer"].(bool); active && q.consumers[0] != c {
+		return true
+	}
+	return false
+}
+
+// expire dead-letters messages at the head of the queue whose TTL ran out,
+// the same way RabbitMQ does. It must be called with the broker lock held.
+func (q *memoryQueue) expire() {
+	now := time.Now()
+	for len(q.messages) > 0 {
+		msg := q.messages[0]
+		if msg.expires.IsZero() || now.Before(msg.expires) {
+			return
+		}
+		q.messages = q.messages[1:]
+		q.broker.deadLetter(q, msg, "expired")
+	}
+}
+
+func tableInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	}
+	return 0, false
+}
+
+// requeue must be called with the broker lock held.
+func (q *memoryQueue) requeue(msgs []memoryMessage) {
+	for i := range msgs {
+		msgs[i].redelivered = true
+	}
+	q.messages = append(msgs, q.messages...)
+	q.cond.Broadcast()
+}
+
+func (c *memoryConsumer) run() {
+	b := c.broker
+	for {
+		b.mu.Lock()
+		c.queue.expire()
+		for !c.cancelled && c.queue.waiting(c) {
+			c.queue.cond.Wait()
+			c.queue.expire()
+		}
+		if c.cancelled {
+			b.mu.Unlock()
+			close(c.deliveries)
+			return
+		}
+
+		msg := c.queue.messages[0]
+		c.queue.messages = c.queue.messages[1:]
+		c.nextTag++
+		tag := c.nextTag
+		c.unacked[tag] = msg
+		b.mu.Unlock()
+
+		select {
+		case c.deliveries <- c.delivery(tag, msg):
+		case <-c.done:
+			b.mu.Lock()
+			// Close may have requeued it already with everything unacked.
+			if _, ok := c.unacked[tag]; ok {
+				delete(c.unacked, tag)
+				c.queue.requeue([]memoryMessage{msg})
+			}
+			b.mu.Unlock()
+			close(c.deliveries)
+			return
+		}
+	}
+}
+
+func (c *memoryConsumer) delivery(tag uint64, msg memoryMessage) amqp.Delivery {
+	p := msg.publishing
+	return amqp.Delivery{
+		Acknowledger:    c,
+		Headers:         p.Headers,
+		ContentType:     p.ContentType,
+		ContentEncoding: p.ContentEncoding,
+		DeliveryMode:    p.DeliveryMode,
+		Priority:        p.Priority,
+		CorrelationId:   p.CorrelationId,
+		ReplyTo:         p.ReplyTo,
+		Expiration:      p.Expiration,
+		MessageId:       p.MessageId,
+		Timestamp:       p.Timestamp,
+		Type:            p.Type,
+		UserId:          p.UserId,
+		AppId:           p.AppId,
+		ConsumerTag:     c.tag,
+		DeliveryTag:     tag,
+		Redelivered:     msg.redelivered,
+		Exchange:        msg.exchange,
+		RoutingKey:      msg.routingKey,
+		Body:            p.Body,
+	}
+}
+
+func (c *memoryConsumer) Deliveries() <-chan amqp.Delivery {
+	return c.deliveries
+}
+
+func (c *memoryConsumer) Cancel() error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	c.cancel()
+	return nil
+}
+
+func (c *memoryConsumer) Close() error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	c.cancel()
+
+	msgs, _ := c.settle(c.nextTag, true)
+	if len(msgs) > 0 {
+		c.queue.requeue(msgs)
+	}
+	return nil
+}
+
+// cancel must be called with the broker lock held.
+func (c *memoryConsumer) cancel() {
+	if c.cancelled {
+		return
+	}
+	c.cancelled = true
+	close(c.done)
+
+	for i, consumer := range c.queue.consumers {
+		if consumer == c {
+			c.queue.consumers = append(c.queue.consumers[:i], c.queue.consumers[i+1:]...)
+			break
+		}
+	}
+	c.queue.cond.Broadcast()
+}
+
+// settle removes the acknowledged tags from the unacked set. It must be
+// called with the broker lock held.
+func (c *memoryConsumer) settle(tag uint64, multiple bool) ([]memoryMessage, error) {
+	if !multiple {
+		msg, ok := c.unacked[tag]
+		if !ok {
+			return nil, fmt.Errorf("unknown delivery tag %d", tag)
+		}
+		delete(c.unacked, tag)
+		return []memoryMessage{msg}, nil
+	}
+
+	msgs := []memoryMessage{}
+	for t := uint64(1); t <= tag; t++ {
+		if msg, ok := c.unacked[t]; ok {
+			msgs = append(msgs, msg)
+			delete(c.unacked, t)
+		}
+	}
+	return msgs, nil
+}
+
+func (c *memoryConsumer) Ack(tag uint64, multiple bool) error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+
+	_, err := c.settle(tag, multiple)
+	if err != nil {
+		return err
+	}
+	c.queue.cond.Broadcast()
+	return nil
+}
+
+func (c *memoryConsumer) Nack(tag uint64, multiple bool, requeue bool) error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+
+	msgs, err := c.settle(tag, multiple)
+	if err != nil {
+		return err
+	}
+
+	if requeue {
+		c.queue.requeue(msgs)
+		return nil
+	}
+	for _, msg := range msgs {
+		c.broker.deadLetter(c.queue, msg, "rejected")
+	}
+	c.queue.cond.Broadcast()
+	return nil
+}
+
+func (c *memoryConsumer) Reject(tag uint64, requeue bool) error {
+	return c.Nack(tag, false, requeue)
+}
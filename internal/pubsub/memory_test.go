@@ -0,0 +1,254 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const testTimeout = 2 * time.Second
+
+func newTestBroker(t *testing.T, exchanges map[string]string) *MemoryBroker {
+	t.Helper()
+	b := NewMemoryBroker()
+	t.Cleanup(func() { b.Close() })
+	for name, kind := range exchanges {
+		if err := b.DeclareExchange(name, kind); err != nil {
+			t.Fatalf("declaring %s: %v", name, err)
+		}
+	}
+	return b
+}
+
+func mustPublish(t *testing.T, b *MemoryBroker, exchange, key, body string) {
+	t.Helper()
+	err := b.Publish(context.Background(), exchange, key, amqp.Publishing{Body: []byte(body)})
+	if err != nil {
+		t.Fatalf("publishing %s to %s: %v", key, exchange, err)
+	}
+}
+
+func mustConsume(t *testing.T, b *MemoryBroker, queueName string, prefetch int) Consumer {
+	t.Helper()
+	c, err := b.Consume(queueName, prefetch)
+	if err != nil {
+		t.Fatalf("consuming %s: %v", queueName, err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func receive(t *testing.T, c Consumer) amqp.Delivery {
+	t.Helper()
+	select {
+	case delivery, ok := <-c.Deliveries():
+		if !ok {
+			t.Fatal("deliveries closed")
+		}
+		return delivery
+	case <-time.After(testTimeout):
+		t.Fatal("no delivery")
+	}
+	return amqp.Delivery{}
+}
+
+func receiveNothing(t *testing.T, c Consumer) {
+	t.Helper()
+	select {
+	case delivery := <-c.Deliveries():
+		t.Fatalf("unexpected delivery %q", delivery.Body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestTopicMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{"intents.*", "intents.alice", true},
+		{"intents.*", "intents", false},
+		{"intents.*", "intents.alice.bob", false},
+		{"*.alice", "state.alice", true},
+		{"army_moves.#", "army_moves", true},
+		{"army_moves.#", "army_moves.alice.bob", true},
+		{"#", "anything.at.all", true},
+		{"#.alice", "state.alice", true},
+		{"#.alice", "alice", true},
+		{"chat.#.bob", "chat.direct.x.bob", true},
+		{"chat.#.bob", "chat.direct.alice", false},
+		{"state.alice", "state.alice", true},
+		{"state.alice", "state.bob", false},
+	}
+	for _, tt := range tests {
+		got := bindingMatches(amqp.ExchangeTopic, tt.pattern, tt.key)
+		if got != tt.want {
+			t.Errorf("%q matching %q = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryBrokerRouting(t *testing.T) {
+	b := newTestBroker(t, map[string]string{
+		"direct": amqp.ExchangeDirect,
+		"topic":  amqp.ExchangeTopic,
+		"fanout": amqp.ExchangeFanout,
+	})
+	bind := func(exchange, queueName, key string) {
+		t.Helper()
+		err := b.DeclareAndBindQueue(exchange, queueName, key, QueueOptions{Type: QueueTypeDurable})
+		if err != nil {
+			t.Fatalf("binding %s: %v", queueName, err)
+		}
+	}
+	bind("direct", "pause", "pause")
+	bind("topic", "alice", "state.alice")
+	bind("topic", "everyone", "state.*")
+	bind("fanout", "first", "ignored")
+	bind("fanout", "second", "")
+
+	mustPublish(t, b, "direct", "pause", "paused")
+	mustPublish(t, b, "direct", "resume", "nowhere")
+	mustPublish(t, b, "topic", "state.alice", "for alice")
+	mustPublish(t, b, "topic", "state.bob", "for bob")
+	mustPublish(t, b, "fanout", "whatever", "for all")
+	mustPublish(t, b, "", "pause", "by queue name")
+
+	want := map[string]int{"pause": 2, "alice": 1, "everyone": 2, "first": 1, "second": 1}
+	for queueName, n := range want {
+		if got := b.QueueLength(queueName); got != n {
+			t.Errorf("%s has %d messages, want %d", queueName, got, n)
+		}
+	}
+
+	c := mustConsume(t, b, "alice", 0)
+	if got := string(receive(t, c).Body); got != "for alice" {
+		t.Errorf("alice got %q", got)
+	}
+
+	err := b.Publish(context.Background(), "missing", "key", amqp.Publishing{})
+	if err == nil {
+		t.Error("publishing to a missing exchange didn't fail")
+	}
+}
+
+func TestMemoryBrokerDeadLettersDiscardedMessages(t *testing.T) {
+	b := newTestBroker(t, map[string]string{
+		"topic": amqp.ExchangeTopic,
+		"dlx":   amqp.ExchangeFanout,
+	})
+	err := b.DeclareAndBindQueue("topic", "work", "work.*", QueueOptions{
+		Type:       QueueTypeDurable,
+		DeadLetter: &DeadLetterTarget{Exchange: "dlx"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.DeclareAndBindQueue("dlx", "dead", "", QueueOptions{Type: QueueTypeDurable})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mustPublish(t, b, "topic", "work.bad", "poison")
+	c := mustConsume(t, b, "work", 0)
+	if err := receive(t, c).Nack(false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	dead := receive(t, mustConsume(t, b, "dead", 0))
+	if string(dead.Body) != "poison" || dead.RoutingKey != "work.bad" {
+		t.Errorf("dead letter is %q with key %q", dead.Body, dead.RoutingKey)
+	}
+	if reason := dead.Headers["x-first-death-reason"]; reason != "rejected" {
+		t.Errorf("first death reason is %v, want rejected", reason)
+	}
+	deaths, _ := dead.Headers["x-death"].([]interface{})
+	if len(deaths) != 1 || deaths[0].(amqp.Table)["queue"] != "work" {
+		t.Errorf("x-death is %v", dead.Headers["x-death"])
+	}
+	if n := b.QueueLength("work"); n != 0 {
+		t.Errorf("work still has %d messages", n)
+	}
+}
+
+func TestMemoryBrokerRequeue(t *testing.T) {
+	b := newTestBroker(t, nil)
+	if err := b.DeclareQueue("work", QueueOptions{Type: QueueTypeDurable}); err != nil {
+		t.Fatal(err)
+	}
+	mustPublish(t, b, "", "work", "first")
+	mustPublish(t, b, "", "work", "second")
+
+	c := mustConsume(t, b, "work", 0)
+	first := receive(t, c)
+	if first.Redelivered {
+		t.Error("a new message is marked redelivered")
+	}
+	if err := first.Nack(false, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The requeued message goes back to the head of the queue, behind the
+	// one the consumer already took.
+	second := receive(t, c)
+	again := receive(t, c)
+	if string(second.Body) != "second" || string(again.Body) != "first" || !again.Redelivered {
+		t.Errorf("got %q then %q (redelivered %v)", second.Body, again.Body, again.Redelivered)
+	}
+	second.Ack(false)
+	again.Ack(false)
+	receiveNothing(t, c)
+}
+
+func TestMemoryBrokerCloseRequeuesUnacked(t *testing.T) {
+	b := newTestBroker(t, nil)
+	if err := b.DeclareQueue("work", QueueOptions{Type: QueueTypeDurable}); err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"a", "b", "c"} {
+		mustPublish(t, b, "", "work", body)
+	}
+
+	c, err := b.Consume("work", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, c)
+	c.Close()
+	for range c.Deliveries() {
+	}
+
+	if n := b.QueueLength("work"); n != 3 {
+		t.Errorf("work has %d messages after close, want 3", n)
+	}
+}
+
+func TestMemoryBrokerPrefetch(t *testing.T) {
+	b := newTestBroker(t, nil)
+	if err := b.DeclareQueue("work", QueueOptions{Type: QueueTypeDurable}); err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"a", "b", "c", "d"} {
+		mustPublish(t, b, "", "work", body)
+	}
+
+	c := mustConsume(t, b, "work", 2)
+	first := receive(t, c)
+	receive(t, c)
+	receiveNothing(t, c)
+	if n := b.QueueLength("work"); n != 2 {
+		t.Errorf("work has %d messages with 2 unacked, want 2", n)
+	}
+
+	first.Ack(false)
+	if got := string(receive(t, c).Body); got != "c" {
+		t.Errorf("got %q after an ack, want c", got)
+	}
+	receiveNothing(t, c)
+}
+
+// TestMemoryBrokerGame runs the server's world and two clients on one
+// broker: the clients send intents, the server answers with state deltas.
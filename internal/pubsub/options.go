@@ -0,0 +1,203 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// DecodeErrorHeader holds the reason a message couldn't be decoded when
+	// it is sent to the dead-letter exchange.
+	DecodeErrorHeader = "x-decode-error"
+	// DeadLetterReasonHeader is set on messages the subscriber dead-letters
+	// itself, since RabbitMQ's x-death only covers ones it dead-lettered.
+	DeadLetterReasonHeader = "x-dead-letter-reason"
+	// VerifyErrorHeader holds why a message failed the check set with
+	// WithVerify when it is sent to the dead-letter exchange.
+	VerifyErrorHeader = "x-verify-error"
+)
+
+// SubscriptionError is reported to the error handler when a subscription
+// can't decode, acknowledge or dead-letter a delivery. The subscription
+// keeps consuming afterwards.
+type SubscriptionError struct {
+	Queue string
+	Op    string
+	Err   error
+}
+
+func (e *SubscriptionError) Error() string {
+	return fmt.Sprintf("queue '%s': %s: %v", e.Queue, e.Op, e.Err)
+}
+
+func (e *SubscriptionError) Unwrap() error {
+	return e.Err
+}
+
+type SubscribeOption func(*subscribeOptions)
+
+type subscribeOptions struct {
+	onError      func(error)
+	publisher    Publisher
+	defaultCodec Codec
+	retry        *RetryPolicy
+	workers      int
+	prefetch     int
+	orderKey     func(msg any) string
+	verify       func(origin Origin, msg any) error
+}
+
+// WithDefaultCodec sets the codec used for deliveries that have no content
+// type. Without it such deliveries are dead-lettered.
+func WithDefaultCodec(codec Codec) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.defaultCodec = codec
+	}
+}
+
+// WithErrorHandler replaces the default of logging subscription errors.
+func WithErrorHandler(onError func(error)) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.onError = onError
+	}
+}
+
+// WithPublisher sets what republishes deliveries that are dead-lettered with
+// an error attached or sent off to be retried. By default the subscriber
+// itself is used if it can publish, otherwise undecodable messages are
+// rejected and dead-lettered without the error and retries fall back to
+// requeueing.
+func WithPublisher(pub Publisher) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.publisher = pub
+	}
+}
+
+// Origin is where a delivery was published and by whom, as far as the
+// broker vouches for it.
+type Origin struct {
+	// Key is the routing key the message was first published with.
+	Key string
+	// UserID is the user_id the message was published with. RabbitMQ
+	// refuses messages whose user_id isn't the user the publisher logged
+	// in as, so it names the sender. Empty if the publisher didn't set it.
+	UserID string
+}
+
+// WithVerify checks every decoded message against where it came from
+// before the handler sees it, like a username in the key or the user that
+// published it having to match the message. Messages that fail are
+// dead-lettered with the error attached. T must be the subscription's
+// message type, messages of any other type always fail.
+//
+// A retried message keeps the origin it was first published with.
+func WithVerify[T any](verify func(origin Origin, msg T) error) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.verify = func(origin Origin, msg any) error {
+			typed, ok := msg.(T)
+			if !ok {
+				return fmt.Errorf("unexpected message type %T", msg)
+			}
+			return verify(origin, typed)
+		}
+	}
+}
+
+func newSubscribeOptions(sub Subscriber, opts []SubscribeOption) *subscribeOptions {
+	options := &subscribeOptions{
+		onError: func(err error) {
+			log.Println("Subscription error: ", err)
+		},
+	}
+	if pub, ok := sub.(Publisher); ok {
+		options.publisher = pub
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+func (o *subscribeOptions) report(err error) {
+	o.onError(err)
+}
+
+// poison sends a delivery that couldn't be decoded to the dead-letter
+// exchange with the decode error attached, then acks the original.
+func (o *subscribeOptions) poison(queueName string, delivery amqp.Delivery, decodeErr error) {
+	poisonedCounter(queueName).Add(1)
+	o.report(&SubscriptionError{Queue: queueName, Op: "decode", Err: decodeErr})
+
+	if o.publisher == nil {
+		err := delivery.Nack(false, false)
+		if err != nil {
+			o.report(&SubscriptionError{Queue: queueName, Op: "ack", Err: err})
+		}
+		return
+	}
+
+	o.deadLetter(queueName, delivery, "decode-error", amqp.Table{DecodeErrorHeader: decodeErr.Error()})
+}
+
+// unverified sends a delivery that failed the WithVerify check to the
+// dead-letter exchange with the reason attached, then acks the original.
+func (o *subscribeOptions) unverified(queueName string, delivery amqp.Delivery, verifyErr error) {
+	o.report(&SubscriptionError{Queue: queueName, Op: "verify", Err: verifyErr})
+
+	if o.publisher == nil {
+		err := delivery.Nack(false, false)
+		if err != nil {
+			o.report(&SubscriptionError{Queue: queueName, Op: "ack", Err: err})
+		}
+		return
+	}
+
+	o.deadLetter(queueName, delivery, "unverified", amqp.Table{VerifyErrorHeader: verifyErr.Error()})
+}
+
+// deadLetter republishes a delivery to peril_dlx with extra headers
+// explaining why, then acks the original.
+func (o *subscribeOptions) deadLetter(queueName string, delivery amqp.Delivery, reason string, extra amqp.Table) {
+	headers := originHeaders(queueName, delivery)
+	for k, v := range extra {
+		headers[k] = v
+	}
+	headers[DeadLetterReasonHeader] = reason
+	if _, ok := headers["x-first-death-reason"]; !ok {
+		headers["x-first-death-reason"] = reason
+		headers["x-first-death-queue"] = queueName
+		headers["x-first-death-exchange"] = headers[OriginalExchangeHeader]
+	}
+
+	err := o.publisher.Publish(context.Background(), routing.ExchangePerilDLX, delivery.RoutingKey, republishing(delivery, headers))
+	if err != nil {
+		o.report(&SubscriptionError{Queue: queueName, Op: "dead-letter", Err: err})
+		// Rejecting still dead-letters it through the queue's own
+		// x-dead-letter-exchange, just without the extra headers.
+		err = delivery.Nack(false, false)
+	} else {
+		err = delivery.Ack(false)
+	}
+	if err != nil {
+		o.report(&SubscriptionError{Queue: queueName, Op: "ack", Err: err})
+	}
+}
+
+var poisoned sync.Map // queue name -> *atomic.Int64
+
+func poisonedCounter(queueName string) *atomic.Int64 {
+	counter, _ := poisoned.LoadOrStore(queueName, &atomic.Int64{})
+	return counter.(*atomic.Int64)
+}
+
+// PoisonedMessages returns how many undecodable messages were dead-lettered
+// from a queue since the process started.
+func PoisonedMessages(queueName string) int64 {
+	return poisonedCounter(queueName).Load()
+}
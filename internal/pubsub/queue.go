@@ -0,0 +1,160 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type QueueType int
+
+const (
+	// QueueTypeDurable survives broker restarts and is shared by consumers.
+	QueueTypeDurable QueueType = iota
+	// QueueTypeTransient belongs to one connection and is deleted with it.
+	QueueTypeTransient
+	// QueueTypeQuorum is a durable, replicated queue.
+	QueueTypeQuorum
+	// QueueTypeStream is an append-only log that consumers read without
+	// removing messages.
+	QueueTypeStream
+)
+
+// ParseQueueType is the reverse of QueueType.String.
+func ParseQueueType(s string) (QueueType, error) {
+	for _, t := range []QueueType{QueueTypeDurable, QueueTypeTransient, QueueTypeQuorum, QueueTypeStream} {
+		if t.String() == s {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown queue type '%s'", s)
+}
+
+func (t QueueType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *QueueType) UnmarshalText(text []byte) error {
+	parsed, err := ParseQueueType(string(text))
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
+
+func (t QueueType) String() string {
+	switch t {
+	case QueueTypeDurable:
+		return "durable"
+	case QueueTypeTransient:
+		return "transient"
+	case QueueTypeQuorum:
+		return "quorum"
+	case QueueTypeStream:
+		return "stream"
+	}
+	return fmt.Sprintf("QueueType(%d)", int(t))
+}
+
+// OverflowPolicy is what happens when a queue with MaxLength is full.
+type OverflowPolicy string
+
+const (
+	OverflowDropHead         OverflowPolicy = "drop-head"
+	OverflowRejectPublish    OverflowPolicy = "reject-publish"
+	OverflowRejectPublishDLX OverflowPolicy = "reject-publish-dlx"
+)
+
+// DeadLetterTarget is where a queue sends rejected, expired and overflowing
+// messages. An empty Exchange is the default exchange, which routes by queue
+// name.
+type DeadLetterTarget struct {
+	Exchange   string
+	RoutingKey string
+}
+
+type QueueOptions struct {
+	Type QueueType
+	// MessageTTL expires messages that waited this long. Zero means never.
+	MessageTTL time.Duration
+	// MaxLength caps how many messages the queue holds. Zero means no cap.
+	MaxLength int
+	Overflow  OverflowPolicy
+	// DeadLetter overrides where dead letters go. nil means peril_dlx.
+	DeadLetter *DeadLetterTarget
+	// Lazy keeps messages on disk instead of in memory. Durable and
+	// transient queues only.
+	Lazy                 bool
+	SingleActiveConsumer bool
+}
+
+// Validate reports options that no broker would accept.
+func (o QueueOptions) Validate() error {
+	_, err := o.arguments()
+	return err
+}
+
+func (o QueueOptions) flags() (durable, autoDelete, exclusive bool) {
+	if o.Type == QueueTypeTransient {
+		return false, true, true
+	}
+	return true, false, false
+}
+
+func (o QueueOptions) arguments() (amqp.Table, error) {
+	args := amqp.Table{}
+
+	switch o.Type {
+	case QueueTypeDurable, QueueTypeTransient:
+	case QueueTypeQuorum, QueueTypeStream:
+		if o.Lazy {
+			return nil, fmt.Errorf("%s queues can't be lazy", o.Type)
+		}
+		args["x-queue-type"] = o.Type.String()
+	default:
+		return nil, fmt.Errorf("unknown queue type %v", o.Type)
+	}
+
+	if o.DeadLetter == nil {
+		args["x-dead-letter-exchange"] = routing.ExchangePerilDLX
+	} else {
+		args["x-dead-letter-exchange"] = o.DeadLetter.Exchange
+		if o.DeadLetter.RoutingKey != "" {
+			args["x-dead-letter-routing-key"] = o.DeadLetter.RoutingKey
+		}
+	}
+
+	if o.MessageTTL < 0 {
+		return nil, errors.New("message TTL can't be negative")
+	}
+	if o.MessageTTL > 0 {
+		args["x-message-ttl"] = o.MessageTTL.Milliseconds()
+	}
+
+	if o.MaxLength < 0 {
+		return nil, errors.New("max length can't be negative")
+	}
+	if o.MaxLength > 0 {
+		args["x-max-length"] = int64(o.MaxLength)
+	}
+	switch o.Overflow {
+	case "":
+	case OverflowDropHead, OverflowRejectPublish, OverflowRejectPublishDLX:
+		args["x-overflow"] = string(o.Overflow)
+	default:
+		return nil, fmt.Errorf("unknown overflow policy '%s'", o.Overflow)
+	}
+
+	if o.Lazy {
+		args["x-queue-mode"] = "lazy"
+	}
+	if o.SingleActiveConsumer {
+		args["x-single-active-consumer"] = true
+	}
+
+	return args, nil
+}
@@ -0,0 +1,484 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var ErrDisconnected = errors.New("not connected to RabbitMQ, message was not published")
+
+type ReconnectConfig struct {
+	URL            string
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// PublishBuffer is how many publishes are held while disconnected and
+	// sent once the connection is back. Zero rejects them with ErrDisconnected.
+	PublishBuffer int
+}
+
+// ReconnectingBroker is an AMQP Broker that survives broker restarts. It
+// remembers every exchange, queue and consumer it was asked for and sets
+// them all up again after re-dialing.
+type ReconnectingBroker struct {
+	config ReconnectConfig
+
+	mu        sync.Mutex
+	conn      *amqp.Connection
+	channel   *amqp.Channel
+	ready     chan struct{}
+	exchanges []exchangeDeclaration
+	queues    []queueDeclaration
+	pending   []pendingPublish
+	closed    bool
+	done      chan struct{}
+}
+
+type exchangeDeclaration struct {
+	name string
+	kind string
+}
+
+// queueDeclaration is a queue to re-declare after reconnecting. Queues
+// without an exchange aren't bound to anything.
+type queueDeclaration struct {
+	exchange  string
+	queueName string
+	key       string
+	options   QueueOptions
+}
+
+func (q queueDeclaration) apply(conn *amqp.Connection) error {
+	if q.exchange == "" {
+		return declareQueue(conn, q.queueName, q.options)
+	}
+	return declareAndBindQueue(conn, q.exchange, q.queueName, q.key, q.options)
+}
+
+type pendingPublish struct {
+	exchange string
+	key      string
+	msg      amqp.Publishing
+}
+
+func DialReconnecting(config ReconnectConfig) (*ReconnectingBroker, error) {
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = 500 * time.Millisecond
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 30 * time.Second
+	}
+
+	b := &ReconnectingBroker{
+		config: config,
+		ready:  make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	err := b.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (b *ReconnectingBroker) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrBrokerClosed
+	}
+
+	if b.channel != nil {
+		err := b.channel.PublishWithContext(ctx, exchange, key, false, false, msg)
+		if !errors.Is(err, amqp.ErrClosed) {
+			return err
+		}
+	}
+
+	if len(b.pending) >= b.config.PublishBuffer {
+		return ErrDisconnected
+	}
+	b.pending = append(b.pending, pendingPublish{exchange: exchange, key: key, msg: msg})
+
+	return nil
+}
+
+func (b *ReconnectingBroker) DeclareExchange(name, kind string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrBrokerClosed
+	}
+	if b.channel == nil {
+		return ErrDisconnected
+	}
+
+	declaration := exchangeDeclaration{name: name, kind: kind}
+	err := b.channel.ExchangeDeclare(name, kind, true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	for _, ex := range b.exchanges {
+		if ex == declaration {
+			return nil
+		}
+	}
+	b.exchanges = append(b.exchanges, declaration)
+
+	return nil
+}
+
+func (b *ReconnectingBroker) DeclareAndBindQueue(exchange, queueName, key string, options QueueOptions) error {
+	return b.declareQueue(queueDeclaration{exchange: exchange, queueName: queueName, key: key, options: options})
+}
+
+func (b *ReconnectingBroker) DeclareQueue(queueName string, options QueueOptions) error {
+	return b.declareQueue(queueDeclaration{queueName: queueName, options: options})
+}
+
+func (b *ReconnectingBroker) declareQueue(declaration queueDeclaration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrBrokerClosed
+	}
+	if b.conn == nil {
+		return ErrDisconnected
+	}
+
+	err := declaration.apply(b.conn)
+	if err != nil {
+		return err
+	}
+	for _, q := range b.queues {
+		if reflect.DeepEqual(q, declaration) {
+			return nil
+		}
+	}
+	b.queues = append(b.queues, declaration)
+
+	return nil
+}
+
+// Consume returns a consumer whose delivery channel stays open across
+// reconnects. It only closes once the consumer is cancelled or the broker
+// itself is closed.
+func (b *ReconnectingBroker) Consume(queueName string, prefetch int) (Consumer, error) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil, ErrBrokerClosed
+	}
+	conn := b.conn
+	b.mu.Unlock()
+	if conn == nil {
+		return nil, ErrDisconnected
+	}
+
+	current, err := consume(conn, queueName, prefetch)
+	if err != nil {
+		return nil, err
+	}
+
+	consumer := &reconnectingConsumer{
+		broker:     b,
+		queueName:  queueName,
+		prefetch:   prefetch,
+		deliveries: make(chan amqp.Delivery),
+		current:    current,
+		cancelled:  make(chan struct{}),
+	}
+	go consumer.forward()
+
+	return consumer, nil
+}
+
+// Channel opens a channel on the current connection. It does not survive a
+// reconnect, callers have to open a new one.
+func (b *ReconnectingBroker) Channel() (*amqp.Channel, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrBrokerClosed
+	}
+	if b.conn == nil {
+		return nil, ErrDisconnected
+	}
+	return b.conn.Channel()
+}
+
+func (b *ReconnectingBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+
+	if b.conn != nil {
+		return b.conn.Close()
+	}
+	return nil
+}
+
+type reconnectingConsumer struct {
+	broker     *ReconnectingBroker
+	queueName  string
+	prefetch   int
+	deliveries chan amqp.Delivery
+
+	mu         sync.Mutex
+	current    *amqpConsumer
+	cancelled  chan struct{}
+	cancelOnce sync.Once
+}
+
+func (c *reconnectingConsumer) Deliveries() <-chan amqp.Delivery {
+	return c.deliveries
+}
+
+func (c *reconnectingConsumer) Cancel() error {
+	c.cancelOnce.Do(func() { close(c.cancelled) })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.current == nil {
+		return nil
+	}
+	return ignoreClosed(c.current.Cancel())
+}
+
+func (c *reconnectingConsumer) Close() error {
+	c.cancelOnce.Do(func() { close(c.cancelled) })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.current == nil {
+		return nil
+	}
+	return ignoreClosed(c.current.Close())
+}
+
+func (c *reconnectingConsumer) isCancelled() bool {
+	select {
+	case <-c.cancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// forward copies deliveries from the current AMQP consumer to the caller and
+// re-subscribes whenever the underlying channel goes away.
+func (c *reconnectingConsumer) forward() {
+	defer close(c.deliveries)
+	b := c.broker
+
+	attempt := 0
+	for {
+		for delivery := range c.current.Deliveries() {
+			attempt = 0
+			delivery.Acknowledger = staleAcknowledger{delivery.Acknowledger}
+			select {
+			case c.deliveries <- delivery:
+			case <-c.cancelled:
+				// Nobody reads them anymore. Closing the channel requeues
+				// what wasn't acked.
+				return
+			case <-b.done:
+				return
+			}
+		}
+		if c.isCancelled() {
+			return
+		}
+
+		for {
+			select {
+			case <-b.waitReady():
+			case <-c.cancelled:
+				return
+			case <-b.done:
+				return
+			}
+
+			b.mu.Lock()
+			conn := b.conn
+			b.mu.Unlock()
+			if conn == nil {
+				continue
+			}
+
+			current, err := consume(conn, c.queueName, c.prefetch)
+			if err == nil {
+				c.mu.Lock()
+				c.current = current
+				c.mu.Unlock()
+				if c.isCancelled() {
+					current.Cancel()
+				}
+				break
+			}
+			log.Printf("Couldn't re-subscribe to '%s': %v", c.queueName, err)
+
+			select {
+			case <-time.After(b.backoff(attempt)):
+			case <-c.cancelled:
+				return
+			case <-b.done:
+				return
+			}
+			attempt++
+		}
+	}
+}
+
+func (b *ReconnectingBroker) waitReady() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.ready
+}
+
+// connect dials RabbitMQ, re-declares the known topology, flushes buffered
+// publishes and starts watching the new connection for failures.
+func (b *ReconnectingBroker) connect() error {
+	conn, err := amqp.Dial(b.config.URL)
+	if err != nil {
+		return err
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		conn.Close()
+		return ErrBrokerClosed
+	}
+
+	for _, ex := range b.exchanges {
+		err = channel.ExchangeDeclare(ex.name, ex.kind, true, false, false, false, nil)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	for _, q := range b.queues {
+		err = q.apply(conn)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
+	for i, p := range b.pending {
+		err = channel.PublishWithContext(context.Background(), p.exchange, p.key, false, false, p.msg)
+		if err != nil {
+			b.pending = b.pending[i:]
+			conn.Close()
+			return err
+		}
+	}
+	b.pending = nil
+
+	b.conn = conn
+	b.channel = channel
+	close(b.ready)
+
+	go b.watch(conn.NotifyClose(make(chan *amqp.Error, 1)), channel.NotifyClose(make(chan *amqp.Error, 1)))
+
+	return nil
+}
+
+func (b *ReconnectingBroker) watch(connClosed, channelClosed <-chan *amqp.Error) {
+	var reason *amqp.Error
+	select {
+	case reason = <-connClosed:
+	case reason = <-channelClosed:
+	case <-b.done:
+		return
+	}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	conn := b.conn
+	b.conn = nil
+	b.channel = nil
+	b.ready = make(chan struct{})
+	b.mu.Unlock()
+
+	if reason != nil {
+		log.Printf("Lost connection to RabbitMQ: %v", reason)
+	}
+	conn.Close()
+
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-time.After(b.backoff(attempt)):
+		case <-b.done:
+			return
+		}
+
+		err := b.connect()
+		if err == nil {
+			log.Println("Reconnected to RabbitMQ.")
+			return
+		}
+		if errors.Is(err, ErrBrokerClosed) {
+			return
+		}
+		log.Printf("Couldn't reconnect to RabbitMQ: %v", err)
+	}
+}
+
+func (b *ReconnectingBroker) backoff(attempt int) time.Duration {
+	delay := b.config.InitialBackoff
+	for i := 0; i < attempt && delay < b.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > b.config.MaxBackoff {
+		delay = b.config.MaxBackoff
+	}
+	return delay
+}
+
+// staleAcknowledger ignores acks for deliveries whose channel has already
+// died. RabbitMQ requeues those messages itself and they'll be redelivered
+// on the new channel.
+type staleAcknowledger struct {
+	amqp.Acknowledger
+}
+
+func (a staleAcknowledger) Ack(tag uint64, multiple bool) error {
+	return ignoreClosed(a.Acknowledger.Ack(tag, multiple))
+}
+
+func (a staleAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	return ignoreClosed(a.Acknowledger.Nack(tag, multiple, requeue))
+}
+
+func (a staleAcknowledger) Reject(tag uint64, requeue bool) error {
+	return ignoreClosed(a.Acknowledger.Reject(tag, requeue))
+}
+
+func ignoreClosed(err error) error {
+	if errors.Is(err, amqp.ErrClosed) {
+		return nil
+	}
+	return err
+}
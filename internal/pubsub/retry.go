@@ -0,0 +1,174 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// RetryAttemptHeader counts how many times a delivery has been retried.
+	RetryAttemptHeader = "x-retry-attempt"
+	// RetryFailureHeader says why a delivery was dead-lettered after its
+	// last retry.
+	RetryFailureHeader = "x-retry-failure"
+	// OriginalExchangeHeader and OriginalRoutingKeyHeader keep where a
+	// message was first published, since retrying and dead-lettering
+	// republish it elsewhere.
+	OriginalExchangeHeader   = "x-original-exchange"
+	OriginalRoutingKeyHeader = "x-original-routing-key"
+	// OriginalUserIDHeader keeps the user_id a message was first published
+	// with. Republishing can't keep the property itself, RabbitMQ only
+	// accepts the publisher's own user there.
+	OriginalUserIDHeader = "x-original-user-id"
+)
+
+// RetryPolicy makes NackRequeue wait before the message is handled again.
+// The message is parked in a retry queue whose TTL dead-letters it back to
+// the origin queue. Attempt n waits Delays[n-1], or the last delay once
+// they run out. After MaxAttempts retries it goes to peril_dlx instead.
+type RetryPolicy struct {
+	Delays      []time.Duration
+	MaxAttempts int
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	Delays:      []time.Duration{time.Second, 5 * time.Second, 30 * time.Second},
+	MaxAttempts: 5,
+}
+
+// WithRetry retries deliveries the handler nacks with NackRequeue according
+// to policy instead of putting them straight back on the queue.
+func WithRetry(policy RetryPolicy) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.retry = &policy
+	}
+}
+
+func retryQueueName(queueName string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%s", queueName, delay)
+}
+
+// RetryQueue is the name and options of the queue that holds queueName's
+// retries for delay and then dead-letters them back to it.
+func RetryQueue(queueName string, delay time.Duration) (string, QueueOptions) {
+	return retryQueueName(queueName, delay), QueueOptions{
+		Type:       QueueTypeDurable,
+		MessageTTL: delay,
+		DeadLetter: &DeadLetterTarget{Exchange: "", RoutingKey: queueName},
+	}
+}
+
+// declareRetryQueues declares the retry queue for every delay in policy.
+func declareRetryQueues(sub Subscriber, queueName string, policy RetryPolicy) error {
+	for _, delay := range policy.Delays {
+		err := sub.DeclareQueue(RetryQueue(queueName, delay))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	if attempt > len(p.Delays) {
+		return p.Delays[len(p.Delays)-1]
+	}
+	return p.Delays[attempt-1]
+}
+
+// retryLater sends a nacked delivery to the retry queue for its next attempt,
+// or to peril_dlx once it is out of attempts, and acks the original.
+func (o *subscribeOptions) retryLater(queueName string, delivery amqp.Delivery) {
+	attempt := 1
+	if n, ok := tableInt(delivery.Headers[RetryAttemptHeader]); ok {
+		attempt = int(n) + 1
+	}
+
+	if attempt > o.retry.MaxAttempts || len(o.retry.Delays) == 0 {
+		o.deadLetter(queueName, delivery, "retries-exhausted", amqp.Table{
+			RetryFailureHeader: fmt.Sprintf("handler still failing after %d retries", attempt-1),
+		})
+		return
+	}
+
+	headers := originHeaders(queueName, delivery)
+	headers[RetryAttemptHeader] = int64(attempt)
+
+	err := o.publisher.Publish(context.Background(), "", retryQueueName(queueName, o.retry.delay(attempt)), republishing(delivery, headers))
+	if err != nil {
+		o.report(&SubscriptionError{Queue: queueName, Op: "retry", Err: err})
+		err = delivery.Nack(false, true)
+	} else {
+		err = delivery.Ack(false)
+	}
+	if err != nil {
+		o.report(&SubscriptionError{Queue: queueName, Op: "ack", Err: err})
+	}
+}
+
+// originHeaders copies a delivery's headers and records where it was first
+// published, unless an earlier republish already did.
+func originHeaders(queueName string, delivery amqp.Delivery) amqp.Table {
+	headers := amqp.Table{}
+	for k, v := range delivery.Headers {
+		headers[k] = v
+	}
+	if _, ok := headers[OriginalExchangeHeader]; !ok {
+		headers[OriginalExchangeHeader] = delivery.Exchange
+		headers[OriginalRoutingKeyHeader] = delivery.RoutingKey
+		headers[OriginalUserIDHeader] = delivery.UserId
+	}
+	return headers
+}
+
+// publishedOrigin is where and by whom a delivery was first published.
+// The origin headers are only taken at their word on deliveries coming back
+// from one of their queue's retry queues, which nothing but the subscriber
+// itself publishes to.
+func publishedOrigin(delivery amqp.Delivery) Origin {
+	if retried(delivery) {
+		key, _ := delivery.Headers[OriginalRoutingKeyHeader].(string)
+		userID, _ := delivery.Headers[OriginalUserIDHeader].(string)
+		return Origin{Key: key, UserID: userID}
+	}
+	return Origin{Key: delivery.RoutingKey, UserID: delivery.UserId}
+}
+
+// retried reports whether RabbitMQ dead-lettered the delivery back to its
+// queue after it expired in one of the queue's retry queues. x-death is set
+// by the broker on the way back, but anyone who can publish to the default
+// exchange can fake it, so players must not be allowed to.
+func retried(delivery amqp.Delivery) bool {
+	if delivery.Exchange != "" {
+		return false
+	}
+	deaths := tableSlice(delivery.Headers["x-death"])
+	if len(deaths) == 0 {
+		return false
+	}
+	death, ok := deaths[0].(amqp.Table)
+	if !ok {
+		return false
+	}
+	queue, _ := death["queue"].(string)
+	return death["reason"] == "expired" && strings.HasPrefix(queue, delivery.RoutingKey+".retry.")
+}
+
+func republishing(delivery amqp.Delivery, headers amqp.Table) amqp.Publishing {
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     delivery.ContentType,
+		ContentEncoding: delivery.ContentEncoding,
+		DeliveryMode:    delivery.DeliveryMode,
+		CorrelationId:   delivery.CorrelationId,
+		MessageId:       delivery.MessageId,
+		Timestamp:       delivery.Timestamp,
+		Type:            delivery.Type,
+		AppId:           delivery.AppId,
+		Body:            delivery.Body,
+	}
+}
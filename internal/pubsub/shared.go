@@ -1,11 +1,7 @@
 package pubsub
 
 import (
-	"bytes"
 	"context"
-	"encoding/gob"
-	"encoding/json"
-	"log"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
@@ -18,13 +14,13 @@ const (
 	NackDiscard
 )
 
-func PublishJSON[T any](ch *amqp.Channel, exchange, key string, val T) error {
-	data, err := json.Marshal(val)
+func Publish[T any](pub Publisher, codec Codec, exchange, key string, val T) error {
+	data, err := codec.Marshal(val)
 	if err != nil {
 		return err
 	}
 
-	err = ch.PublishWithContext(context.Background(), exchange, key, false, false, amqp.Publishing{ContentType: "application/json", Body: data})
+	err = pub.Publish(context.Background(), exchange, key, amqp.Publishing{ContentType: codec.ContentType(), Body: data})
 	if err != nil {
 		return err
 	}
@@ -32,149 +28,106 @@ func PublishJSON[T any](ch *amqp.Channel, exchange, key string, val T) error {
 	return nil
 }
 
-func PublishGob[T any](ch *amqp.Channel, exchange, key string, val T) error {
-	var buff bytes.Buffer
-	encoder := gob.NewEncoder(&buff)
-	err := encoder.Encode(val)
-	if err != nil {
-		return err
-	}
-
-	err = ch.PublishWithContext(context.Background(), exchange, key, false, false, amqp.Publishing{ContentType: "application/gob", Body: buff.Bytes()})
-	if err != nil {
-		return err
-	}
-
-	return nil
+func PublishJSON[T any](pub Publisher, exchange, key string, val T) error {
+	return Publish(pub, JSONCodec, exchange, key, val)
 }
 
-func DeclareAndBindQueue(conn *amqp.Connection, exchange, queueName, key string, simpleQueueType int) (*amqp.Channel, amqp.Queue, error) {
-	channel, err := conn.Channel()
-	if err != nil {
-		return nil, amqp.Queue{}, err
-	}
-
-	var durable, autoDelete, exclusive bool
-	if simpleQueueType == 0 { // "durable"
-		durable = true
-		autoDelete = false
-		exclusive = false
-	} else { // "transient"
-		durable = false
-		autoDelete = true
-		exclusive = true
-	}
-
-	queue, err := channel.QueueDeclare(queueName, durable, autoDelete, exclusive, false, amqp.Table{"x-dead-letter-exchange": "peril_dlx"})
-	if err != nil {
-		return nil, amqp.Queue{}, err
-	}
-
-	err = channel.QueueBind(queueName, key, exchange, false, nil)
-	if err != nil {
-		return nil, amqp.Queue{}, err
-	}
-
-	return channel, queue, nil
+func PublishGob[T any](pub Publisher, exchange, key string, val T) error {
+	return Publish(pub, GobCodec, exchange, key, val)
 }
 
-func DeclareExchange(channel *amqp.Channel, name, kind string) error {
-	err := channel.ExchangeDeclare(name, kind, true, false, false, false, nil)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
+// Subscribe decodes each delivery with the codec registered for its content
+// type, so one queue can take messages from producers using different
+// formats.
+func Subscribe[T any](sub Subscriber, exchange, queueName, key string, queueOptions QueueOptions, handler func(T) AckType, opts ...SubscribeOption) (*Subscription, error) {
+	options := newSubscribeOptions(sub, opts)
 
-func SubscribeJSON[T any](conn *amqp.Connection, exchange, queueName, key string, simpleQueueType int, handler func(T) AckType) error {
-	channel, _, err := DeclareAndBindQueue(conn, exchange, queueName, key, simpleQueueType)
+	err := declareSubscription(sub, exchange, queueName, key, queueOptions, options)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = channel.Qos(10, 0, false)
+	consumer, err := sub.Consume(queueName, options.prefetchCount())
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	deliveries, err := channel.Consume(queueName, "", false, false, false, false, nil)
-	if err != nil {
-		return err
-	}
-
-	go func() {
-		for delivery := range deliveries {
-			var msg T
-			err = json.Unmarshal(delivery.Body, &msg)
+	return startSubscription(queueName, consumer, options, func(delivery amqp.Delivery) (string, func()) {
+		msg, err := decode[T](delivery, options)
+		if err != nil {
+			options.poison(queueName, delivery, err)
+			return "", nil
+		}
+		if options.verify != nil {
+			err = options.verify(publishedOrigin(delivery), msg)
 			if err != nil {
-				log.Fatal("Error unmarshaling message: ", err)
+				options.unverified(queueName, delivery, err)
+				return "", nil
 			}
+		}
+
+		key := ""
+		if options.orderKey != nil {
+			key = options.orderKey(msg)
+		}
 
-			ack_type := handler(msg)
-			switch ack_type {
+		return key, func() {
+			var ackErr error
+			switch handler(msg) {
 			case Ack:
-				err = delivery.Ack(false)
-				break
+				ackErr = delivery.Ack(false)
 			case NackRequeue:
-				err = delivery.Nack(false, true)
-				break
+				if options.retry != nil && options.publisher != nil {
+					options.retryLater(queueName, delivery)
+					return
+				}
+				ackErr = delivery.Nack(false, true)
 			case NackDiscard:
-				err = delivery.Nack(false, false)
-				break
+				ackErr = delivery.Nack(false, false)
 			}
-			if err != nil {
-				log.Fatal("Error acknowledging message: ", err)
+			if ackErr != nil {
+				options.report(&SubscriptionError{Queue: queueName, Op: "ack", Err: ackErr})
 			}
 		}
-	}()
-
-	return nil
+	}), nil
 }
 
-func SubscribeGob[T any](conn *amqp.Connection, exchange, queueName, key string, simpleQueueType int, handler func(T) AckType) error {
-	channel, _, err := DeclareAndBindQueue(conn, exchange, queueName, key, simpleQueueType)
+func decode[T any](delivery amqp.Delivery, options *subscribeOptions) (T, error) {
+	var msg T
+	codec, err := decoderFor(delivery.ContentType, options.defaultCodec)
 	if err != nil {
-		return err
+		return msg, err
 	}
+	err = codec.Unmarshal(delivery.Body, &msg)
+	return msg, err
+}
 
-	err = channel.Qos(10, 0, false)
+// declareSubscription declares the queue a subscription consumes from and
+// its retry queues.
+func declareSubscription(sub Subscriber, exchange, queueName, key string, queueOptions QueueOptions, options *subscribeOptions) error {
+	err := sub.DeclareAndBindQueue(exchange, queueName, key, queueOptions)
 	if err != nil {
 		return err
 	}
 
-	deliveries, err := channel.Consume(queueName, "", false, false, false, false, nil)
-	if err != nil {
-		return err
+	if options.retry != nil {
+		err = declareRetryQueues(sub, queueName, *options.retry)
+		if err != nil {
+			return err
+		}
 	}
 
-	go func() {
-		for delivery := range deliveries {
-			var msg T
-			buff := bytes.NewBuffer(delivery.Body)
-			decoder := gob.NewDecoder(buff)
-			err := decoder.Decode(&msg)
-			if err != nil {
-				log.Fatal("Error decoding message: ", err)
-			}
+	return nil
+}
 
-			ack_type := handler(msg)
-			switch ack_type {
-			case Ack:
-				err = delivery.Ack(false)
-				break
-			case NackRequeue:
-				err = delivery.Nack(false, true)
-				break
-			case NackDiscard:
-				err = delivery.Nack(false, false)
-				break
-			}
-			if err != nil {
-				log.Fatal("Error acknowledging message: ", err)
-			}
-		}
-	}()
+// SubscribeJSON is Subscribe for queues that are mostly JSON. Messages without
+// a content type are decoded as JSON.
+func SubscribeJSON[T any](sub Subscriber, exchange, queueName, key string, queueOptions QueueOptions, handler func(T) AckType, opts ...SubscribeOption) (*Subscription, error) {
+	return Subscribe(sub, exchange, queueName, key, queueOptions, handler, append([]SubscribeOption{WithDefaultCodec(JSONCodec)}, opts...)...)
+}
 
-	return nil
+// SubscribeGob is Subscribe for queues that are mostly gob. Messages without
+// a content type are decoded as gob.
+func SubscribeGob[T any](sub Subscriber, exchange, queueName, key string, queueOptions QueueOptions, handler func(T) AckType, opts ...SubscribeOption) (*Subscription, error) {
+	return Subscribe(sub, exchange, queueName, key, queueOptions, handler, append([]SubscribeOption{WithDefaultCodec(GobCodec)}, opts...)...)
 }
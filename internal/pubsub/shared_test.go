@@ -0,0 +1,118 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type keyedMessage struct {
+	Username string
+}
+
+func verifySender(origin Origin, msg keyedMessage) error {
+	if routing.KeyUsername(origin.Key, "work") != msg.Username {
+		return errors.New("wrong key")
+	}
+	if origin.UserID != msg.Username {
+		return errors.New("wrong user")
+	}
+	return nil
+}
+
+func TestSubscribeVerify(t *testing.T) {
+	b := newTestBroker(t, map[string]string{
+		routing.ExchangePerilTopic: amqp.ExchangeTopic,
+		routing.ExchangePerilDLX:   amqp.ExchangeFanout,
+	})
+	err := b.DeclareAndBindQueue(routing.ExchangePerilDLX, routing.QueuePerilDLQ, "", QueueOptions{Type: QueueTypeDurable})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handled := make(chan keyedMessage, 4)
+	sub, err := SubscribeJSON(b, routing.ExchangePerilTopic, "work", "work.*", QueueOptions{Type: QueueTypeDurable}, func(msg keyedMessage) AckType {
+		handled <- msg
+		return Ack
+	}, WithErrorHandler(func(error) {}), WithVerify(verifySender))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	dlq := mustConsume(t, b, routing.QueuePerilDLQ, 0)
+
+	forged := []struct {
+		exchange string
+		key      string
+		msg      amqp.Publishing
+		reason   string
+	}{
+		{routing.ExchangePerilTopic, "work.bob", amqp.Publishing{UserId: "alice", Body: []byte(`{"Username":"alice"}`)}, "wrong key"},
+		{routing.ExchangePerilTopic, "work.alice", amqp.Publishing{UserId: "bob", Body: []byte(`{"Username":"alice"}`)}, "wrong user"},
+		{routing.ExchangePerilTopic, "work.alice", amqp.Publishing{Body: []byte(`{"Username":"alice"}`)}, "wrong user"},
+		// Origin headers on a message that never went through a retry
+		// queue aren't believed.
+		{"", "work", amqp.Publishing{UserId: "bob", Body: []byte(`{"Username":"alice"}`), Headers: amqp.Table{
+			OriginalExchangeHeader:   routing.ExchangePerilTopic,
+			OriginalRoutingKeyHeader: "work.alice",
+			OriginalUserIDHeader:     "alice",
+		}}, "wrong key"},
+	}
+	for _, f := range forged {
+		if err := b.Publish(context.Background(), f.exchange, f.key, f.msg); err != nil {
+			t.Fatal(err)
+		}
+		dead := receive(t, dlq)
+		if dead.Headers[VerifyErrorHeader] != f.reason {
+			t.Errorf("message sent with key %q by %q failed with %v, want %q", f.key, f.msg.UserId, dead.Headers[VerifyErrorHeader], f.reason)
+		}
+	}
+
+	if err := PublishJSON(AsUser(b, "alice"), routing.ExchangePerilTopic, "work.alice", keyedMessage{Username: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-handled:
+		if msg.Username != "alice" || len(handled) != 0 {
+			t.Error("a forged message reached the handler")
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("the real message wasn't handled")
+	}
+}
+
+func TestSubscribeVerifyRetried(t *testing.T) {
+	b := newTestBroker(t, map[string]string{
+		routing.ExchangePerilTopic: amqp.ExchangeTopic,
+		routing.ExchangePerilDLX:   amqp.ExchangeFanout,
+	})
+
+	attempts := make(chan keyedMessage, 2)
+	sub, err := SubscribeJSON(b, routing.ExchangePerilTopic, "work", "work.*", QueueOptions{Type: QueueTypeDurable}, func(msg keyedMessage) AckType {
+		attempts <- msg
+		if len(attempts) == 1 {
+			return NackRequeue
+		}
+		return Ack
+	}, WithErrorHandler(func(err error) { t.Error(err) }), WithVerify(verifySender), WithRetry(RetryPolicy{Delays: []time.Duration{10 * time.Millisecond}, MaxAttempts: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	if err := PublishJSON(AsUser(b, "alice"), routing.ExchangePerilTopic, "work.alice", keyedMessage{Username: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.After(testTimeout)
+	for len(attempts) < 2 {
+		select {
+		case <-deadline:
+			t.Fatal("the retried message wasn't handled again")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Subscription is a running consumer loop started by SubscribeJSON or
+// SubscribeGob.
+type Subscription struct {
+	queueName string
+	consumer  Consumer
+	stop      chan struct{}
+	stopOnce  sync.Once
+	done      chan struct{}
+}
+
+// startSubscription reads deliveries on one goroutine and hands the jobs
+// prepare returns to the worker pool. prepare returns a nil job for
+// deliveries it already dealt with itself.
+func startSubscription(queueName string, consumer Consumer, options *subscribeOptions, prepare func(amqp.Delivery) (key string, job func())) *Subscription {
+	s := &Subscription{
+		queueName: queueName,
+		consumer:  consumer,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	pool := newWorkerPool(options.workers, options.prefetchCount(), options.orderKey != nil, s.stop)
+
+	go func() {
+		defer close(s.done)
+		defer pool.close()
+		deliveries := consumer.Deliveries()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case delivery, ok := <-deliveries:
+				if !ok {
+					return
+				}
+				key, job := prepare(delivery)
+				if job == nil {
+					continue
+				}
+				if !pool.submit(key, job) {
+					return
+				}
+			}
+		}
+	}()
+
+	return s
+}
+
+func (s *Subscription) Queue() string {
+	return s.queueName
+}
+
+// Done is closed once the consumer loop has exited and no worker is running
+// a handler.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Drain stops new deliveries and waits for everything already received to
+// be handled and acknowledged. If ctx ends first the remaining deliveries
+// are left for the broker to requeue.
+func (s *Subscription) Drain(ctx context.Context) error {
+	cancelErr := s.consumer.Cancel()
+
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		s.stopOnce.Do(func() { close(s.stop) })
+		<-s.done
+	}
+
+	closeErr := s.consumer.Close()
+	if cancelErr != nil {
+		return cancelErr
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return ctx.Err()
+}
+
+// Close waits for the running handlers to finish and stops the subscription.
+// Deliveries that weren't handled yet are requeued.
+func (s *Subscription) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	cancelErr := s.consumer.Cancel()
+	<-s.done
+
+	closeErr := s.consumer.Close()
+	if cancelErr != nil {
+		return cancelErr
+	}
+	return closeErr
+}
+
+// DrainAll drains every subscription, sharing the deadline of ctx.
+func DrainAll(ctx context.Context, subscriptions ...*Subscription) error {
+	var errs []error
+	for _, s := range subscriptions {
+		err := s.Drain(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("draining '%s': %w", s.Queue(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
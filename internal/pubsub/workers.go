@@ -0,0 +1,129 @@
+package pubsub
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// defaultPrefetch is how many unacknowledged deliveries a subscription
+// holds when WithPrefetch isn't given.
+const defaultPrefetch = 10
+
+// WithWorkers runs the handler on n goroutines at once. Without
+// WithOrderKey deliveries go to whichever worker is free, so they can be
+// handled out of order.
+func WithWorkers(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.workers = n
+	}
+}
+
+// WithPrefetch sets how many unacknowledged deliveries the broker sends the
+// subscription ahead of time. It defaults to 10, or the number of workers
+// if that's higher.
+func WithPrefetch(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.prefetch = n
+	}
+}
+
+// WithOrderKey shards deliveries across the workers by key, so messages with
+// the same key are handled one at a time in the order they arrived while
+// different keys proceed in parallel. T must be the subscription's message
+// type, messages of any other type all get the empty key.
+func WithOrderKey[T any](key func(T) string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.orderKey = func(msg any) string {
+			typed, ok := msg.(T)
+			if !ok {
+				return ""
+			}
+			return key(typed)
+		}
+	}
+}
+
+func (o *subscribeOptions) prefetchCount() int {
+	if o.prefetch > 0 {
+		return o.prefetch
+	}
+	if o.workers > defaultPrefetch {
+		return o.workers
+	}
+	return defaultPrefetch
+}
+
+// workerPool runs jobs on a fixed number of goroutines. In ordered mode
+// every worker has its own queue and a key always lands on the same one.
+type workerPool struct {
+	queues  []chan func()
+	ordered bool
+	stop    <-chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newWorkerPool(workers, buffer int, ordered bool, stop <-chan struct{}) *workerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &workerPool{ordered: ordered, stop: stop}
+
+	if ordered {
+		for i := 0; i < workers; i++ {
+			queue := make(chan func(), buffer)
+			p.queues = append(p.queues, queue)
+			p.wg.Add(1)
+			go p.run(queue)
+		}
+		return p
+	}
+
+	queue := make(chan func(), buffer)
+	p.queues = []chan func(){queue}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.run(queue)
+	}
+	return p
+}
+
+// run handles jobs until the queue is closed. Once the subscription is
+// stopped queued jobs are skipped and their deliveries left for the broker
+// to requeue.
+func (p *workerPool) run(queue <-chan func()) {
+	defer p.wg.Done()
+	for job := range queue {
+		select {
+		case <-p.stop:
+			continue
+		default:
+		}
+		job()
+	}
+}
+
+// submit queues a job and reports false if the subscription was stopped
+// while waiting for room.
+func (p *workerPool) submit(key string, job func()) bool {
+	queue := p.queues[0]
+	if p.ordered {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		queue = p.queues[h.Sum32()%uint32(len(p.queues))]
+	}
+
+	select {
+	case queue <- job:
+		return true
+	case <-p.stop:
+		return false
+	}
+}
+
+// close waits for the workers to finish everything already queued.
+func (p *workerPool) close() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
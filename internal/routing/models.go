@@ -6,6 +6,120 @@ type PlayingState struct {
 	IsPaused bool
 }
 
+type TurnPhase string
+
+const (
+	// TurnPhaseOrders is when players send their spawns and moves.
+	TurnPhaseOrders TurnPhase = "orders"
+	// TurnPhaseResolving is when the server carries out every order of the
+	// turn at once.
+	TurnPhaseResolving TurnPhase = "resolving"
+)
+
+// TurnState is announced whenever a turn starts or ends. Turn 0 means turns
+// are off and intents are carried out as they arrive.
+type TurnState struct {
+	Turn  int
+	Phase TurnPhase
+	// Deadline is when the turn ends on its own, zero if only the server
+	// ends it.
+	Deadline time.Time
+}
+
+// GameOver is announced once someone has won, or nobody can any more.
+type GameOver struct {
+	// Winner is empty if nobody won.
+	Winner    string
+	Reason    string
+	Standings []Standing
+}
+
+// Standing is how well a player did, best first in GameOver.
+type Standing struct {
+	Username    string
+	Territories int
+	Power       int
+	Gold        int
+	Eliminated  bool
+}
+
+type PactKind string
+
+const (
+	// PactAlliance lets both players' units share locations without a war.
+	PactAlliance PactKind = "alliance"
+	// PactTruce keeps both players from attacking each other for a while.
+	PactTruce PactKind = "truce"
+	// PactNonAggression keeps both players from attacking each other until
+	// one of them breaks it.
+	PactNonAggression PactKind = "non_aggression"
+)
+
+type DiplomacyAction string
+
+const (
+	DiplomacyPropose DiplomacyAction = "propose"
+	DiplomacyAccept  DiplomacyAction = "accept"
+	DiplomacyReject  DiplomacyAction = "reject"
+	DiplomacyBreak   DiplomacyAction = "break"
+)
+
+// Diplomacy is a message from one player to another, published to
+// diplomacy.<To>.<From>. Answers carry the ID of the proposal they answer.
+type Diplomacy struct {
+	ID     string
+	Action DiplomacyAction
+	Pact   PactKind
+	From   string
+	To     string
+	// Duration is how long a proposed truce lasts once accepted.
+	Duration time.Duration
+	SentAt   time.Time
+}
+
+// DiplomacyKey is the routing key a diplomatic message goes out with.
+func DiplomacyKey(to, from string) string {
+	return DiplomacyPrefix + "." + to + "." + from
+}
+
+type ChatChannel string
+
+const (
+	ChatGlobal   ChatChannel = "global"
+	ChatAlliance ChatChannel = "alliance"
+	ChatDirect   ChatChannel = "direct"
+	// ChatSend is the channel players send to, the server passes messages
+	// on to the channel they are for.
+	ChatSend ChatChannel = "send"
+	// ChatSystem is the server telling a player something, like why their
+	// message wasn't sent.
+	ChatSystem ChatChannel = "system"
+	// ChatHistory asks the server for recent messages, which it answers
+	// with a ChatHistory message holding them in History.
+	ChatHistory ChatChannel = "history"
+)
+
+// ChatMessage is one message in the in-game chat. To is who a direct message
+// or a reply from the server is for. Players pick the channel, the server
+// fills in ID.
+type ChatMessage struct {
+	ID      uint64
+	Channel ChatChannel
+	From    string
+	To      string
+	Text    string
+	SentAt  time.Time
+	History []ChatMessage
+}
+
+// ChatKey is the routing key a message on channel goes out with.
+func ChatKey(channel ChatChannel, username string) string {
+	if channel == ChatGlobal {
+		return ChatPrefix + "." + string(ChatGlobal)
+	}
+	return ChatPrefix + "." + string(channel) + "." + username
+}
+
 type GameLog struct {
 	CurrentTime time.Time
 	Message     string
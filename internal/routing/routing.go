@@ -1,5 +1,7 @@
 package routing
 
+import "strings"
+
 const (
 	ArmyMovesPrefix = "army_moves"
 
@@ -7,10 +9,56 @@ const (
 
 	PauseKey = "pause"
 
+	TurnKey = "turn"
+
+	GameOverKey = "game_over"
+
 	GameLogSlug = "game_logs"
+
+	// IntentsPrefix is followed by the username of the player asking.
+	IntentsPrefix = "intents"
+
+	// StatePrefix is followed by the username of the player a state delta
+	// is about. Every player gets those, private ones go out with
+	// PrivateStateKey instead.
+	StatePrefix = "state"
+
+	// EventsPrefix names the queues the server records game events from.
+	EventsPrefix = "events"
+
+	// DiplomacyPrefix is followed by the username of the player a
+	// diplomatic message is for and then the one who sent it.
+	DiplomacyPrefix = "diplomacy"
+
+	// ChatPrefix is followed by the channel a chat message goes out on:
+	// chat.global, chat.alliance.<username> or chat.direct.<username>.
+	// Players send theirs to chat.send.<username> for the server to check
+	// first.
+	ChatPrefix = "chat"
 )
 
 const (
 	ExchangePerilDirect = "peril_direct"
 	ExchangePerilTopic  = "peril_topic"
+	ExchangePerilDLX    = "peril_dlx"
+)
+
+const (
+	QueuePerilDLQ = "peril_dlq"
 )
+
+// KeyUsername is the username a routing key like intents.<username> ends
+// with, or "" if key isn't prefix followed by a username.
+func KeyUsername(key, prefix string) string {
+	username, ok := strings.CutPrefix(key, prefix+".")
+	if !ok || strings.Contains(username, ".") {
+		return ""
+	}
+	return username
+}
+
+// PrivateStateKey is the routing key of state deltas only the player they
+// are about gets.
+func PrivateStateKey(username string) string {
+	return StatePrefix + "." + username + ".private"
+}
@@ -0,0 +1,147 @@
+// Package snapshot saves game state to disk in a versioned JSON envelope so
+// older snapshots can still be read after the state changes shape.
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Version is the format Save writes. Bump it and add a migration whenever a
+// saved type changes in a way old snapshots can't be decoded into.
+const Version = 1
+
+// DefaultDir is where the server and clients keep their snapshots.
+const DefaultDir = "snapshots"
+
+var ErrNotFound = errors.New("no snapshot saved")
+
+type envelope struct {
+	Version int             `json:"version"`
+	Kind    string          `json:"kind"`
+	SavedAt time.Time       `json:"saved_at"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// migrations upgrade the data of a snapshot from the version they're keyed
+// by to the next one.
+var migrations = map[int]func(kind string, data json.RawMessage) (json.RawMessage, error){}
+
+// Save writes data to path, replacing an older snapshot only once the new
+// one is completely on disk.
+func Save(path, kind string, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	encoded, err := json.MarshalIndent(envelope{Version: Version, Kind: kind, SavedAt: time.Now(), Data: raw}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(encoded)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load reads the snapshot at path into data and returns when it was saved.
+// It fails with ErrNotFound if nothing was saved there yet.
+func Load(path, kind string, data any) (time.Time, error) {
+	encoded, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, ErrNotFound
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var env envelope
+	err = json.Unmarshal(encoded, &env)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("snapshot '%s' is corrupt: %w", path, err)
+	}
+	if env.Kind != kind {
+		return time.Time{}, fmt.Errorf("snapshot '%s' holds %s state, not %s", path, env.Kind, kind)
+	}
+	if env.Version > Version {
+		return time.Time{}, fmt.Errorf("snapshot '%s' is version %d, this build only reads up to %d", path, env.Version, Version)
+	}
+	for env.Version < Version {
+		migrate, ok := migrations[env.Version]
+		if !ok {
+			return time.Time{}, fmt.Errorf("snapshot '%s' is version %d, which can't be upgraded", path, env.Version)
+		}
+		env.Data, err = migrate(env.Kind, env.Data)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("upgrading snapshot '%s' from version %d: %w", path, env.Version, err)
+		}
+		env.Version++
+	}
+
+	err = json.Unmarshal(env.Data, data)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("snapshot '%s' is corrupt: %w", path, err)
+	}
+	return env.SavedAt, nil
+}
+
+// PlayerPath is where the snapshot of a player's client is kept.
+func PlayerPath(dir, username string) (string, error) {
+	if username == "" || strings.ContainsAny(username, `/\`) || username == "." || username == ".." {
+		return "", fmt.Errorf("'%s' can't be used as a snapshot name", username)
+	}
+	return filepath.Join(dir, "player-"+username+".json"), nil
+}
+
+// WorldPath is where the server keeps the snapshot of the world.
+func WorldPath(dir string) string {
+	return filepath.Join(dir, "world.json")
+}
+
+// Autosave calls save every interval until ctx ends, logging failures
+// through onError.
+func Autosave(ctx context.Context, interval time.Duration, save func() error, onError func(error)) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := save()
+				if err != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
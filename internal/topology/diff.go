@@ -0,0 +1,153 @@
+package topology
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// Diff compares the desired topology with what Inspect found and returns one
+// line per difference: "+" for something missing from the broker, "-" for
+// something the topology doesn't know about and "~" for something declared
+// differently. Queues on the broker that match a per-player template are
+// compared against the template filled in with their username.
+func Diff(desired, actual Topology) []string {
+	lines := []string{}
+	desired = desired.expanded()
+
+	actualExchanges := map[string]Exchange{}
+	for _, ex := range actual.Exchanges {
+		actualExchanges[ex.Name] = ex
+	}
+	desiredExchanges := map[string]bool{}
+	for _, ex := range desired.Exchanges {
+		desiredExchanges[ex.Name] = true
+		found, ok := actualExchanges[ex.Name]
+		if !ok {
+			lines = append(lines, fmt.Sprintf("+ exchange '%s' (%s)", ex.Name, ex.Kind))
+		} else if found.Kind != ex.Kind {
+			lines = append(lines, fmt.Sprintf("~ exchange '%s' is %s, want %s", ex.Name, found.Kind, ex.Kind))
+		}
+	}
+	for _, ex := range actual.Exchanges {
+		if !desiredExchanges[ex.Name] {
+			lines = append(lines, fmt.Sprintf("- exchange '%s' (%s)", ex.Name, ex.Kind))
+		}
+	}
+
+	actualQueues := map[string]Queue{}
+	for _, q := range actual.Queues {
+		actualQueues[q.Name] = q
+	}
+	for _, q := range desired.Queues {
+		if q.Templated() {
+			continue
+		}
+		found, ok := actualQueues[q.Name]
+		if !ok {
+			lines = append(lines, fmt.Sprintf("+ queue '%s' (%s)", q.Name, q.withDefaults().describe()))
+			continue
+		}
+		lines = append(lines, diffQueue(q, found)...)
+	}
+	for _, q := range actual.Queues {
+		want, ok := desired.match(q.Name)
+		if !ok {
+			lines = append(lines, fmt.Sprintf("- queue '%s' (%s)", q.Name, q.describe()))
+			continue
+		}
+		if want.Templated() {
+			lines = append(lines, diffQueue(want.forUser(username(want.Name, q.Name)), q)...)
+		}
+	}
+
+	return lines
+}
+
+func diffQueue(want, have Queue) []string {
+	lines := []string{}
+	if want.withDefaults().describe() != have.describe() {
+		lines = append(lines, fmt.Sprintf("~ queue '%s' is (%s), want (%s)", want.Name, have.describe(), want.withDefaults().describe()))
+	}
+
+	haveBindings := map[Binding]bool{}
+	for _, b := range have.Bindings {
+		haveBindings[b] = true
+	}
+	wantBindings := map[Binding]bool{}
+	for _, b := range want.Bindings {
+		wantBindings[b] = true
+		if !haveBindings[b] {
+			lines = append(lines, fmt.Sprintf("+ binding '%s' -> '%s' with key '%s'", b.Exchange, want.Name, b.Key))
+		}
+	}
+	for _, b := range have.Bindings {
+		if !wantBindings[b] {
+			lines = append(lines, fmt.Sprintf("- binding '%s' -> '%s' with key '%s'", b.Exchange, want.Name, b.Key))
+		}
+	}
+
+	return lines
+}
+
+// match finds the queue declaration a queue on the broker belongs to.
+func (t Topology) match(name string) (Queue, bool) {
+	for _, q := range t.Queues {
+		if q.Name == name {
+			return q, true
+		}
+	}
+	for _, q := range t.Queues {
+		if q.Templated() && username(q.Name, name) != "" {
+			return q, true
+		}
+	}
+	return Queue{}, false
+}
+
+// username pulls the username out of a queue name made from template, or
+// returns "" if the name doesn't fit the template.
+func username(template, name string) string {
+	prefix, suffix, _ := strings.Cut(template, UsernamePlaceholder)
+	if len(name) <= len(prefix)+len(suffix) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return ""
+	}
+	return name[len(prefix) : len(name)-len(suffix)]
+}
+
+// withDefaults spells out the dead-letter exchange declaring the queue would
+// set when the topology leaves it out.
+func (q Queue) withDefaults() Queue {
+	if q.DeadLetter == nil {
+		q.DeadLetter = &DeadLetter{Exchange: routing.ExchangePerilDLX}
+	}
+	return q
+}
+
+func (q Queue) describe() string {
+	settings := []string{q.Type.String()}
+	if q.MessageTTL > 0 {
+		settings = append(settings, "ttl "+q.MessageTTL.String())
+	}
+	if q.MaxLength > 0 {
+		settings = append(settings, fmt.Sprintf("max length %d", q.MaxLength))
+	}
+	if q.Overflow != "" {
+		settings = append(settings, "overflow "+string(q.Overflow))
+	}
+	if q.DeadLetter == nil {
+		settings = append(settings, "no dead-lettering")
+	} else if q.DeadLetter.RoutingKey != "" {
+		settings = append(settings, fmt.Sprintf("dead-letter to '%s' with key '%s'", q.DeadLetter.Exchange, q.DeadLetter.RoutingKey))
+	} else {
+		settings = append(settings, fmt.Sprintf("dead-letter to '%s'", q.DeadLetter.Exchange))
+	}
+	if q.Lazy {
+		settings = append(settings, "lazy")
+	}
+	if q.SingleActiveConsumer {
+		settings = append(settings, "single active consumer")
+	}
+	return strings.Join(settings, ", ")
+}
@@ -0,0 +1,430 @@
+// Package topology describes every exchange, queue and binding Peril uses as
+// data, so the server, the clients and the topology tool all declare the
+// same thing and a running broker can be checked against it.
+package topology
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"gopkg.in/yaml.v3"
+)
+
+// UsernamePlaceholder stands for a player's name in queue names and binding
+// keys of per-player queues. ForUser fills it in.
+const UsernamePlaceholder = "{username}"
+
+type Topology struct {
+	Exchanges []Exchange `yaml:"exchanges" json:"exchanges"`
+	Queues    []Queue    `yaml:"queues" json:"queues"`
+}
+
+type Exchange struct {
+	Name string `yaml:"name" json:"name"`
+	Kind string `yaml:"kind" json:"kind"`
+}
+
+type Queue struct {
+	Name       string                `yaml:"name" json:"name"`
+	Type       pubsub.QueueType      `yaml:"type" json:"type"`
+	MessageTTL Duration              `yaml:"message_ttl,omitempty" json:"message_ttl,omitempty"`
+	MaxLength  int                   `yaml:"max_length,omitempty" json:"max_length,omitempty"`
+	Overflow   pubsub.OverflowPolicy `yaml:"overflow,omitempty" json:"overflow,omitempty"`
+	// DeadLetter overrides where dead letters go. nil means peril_dlx.
+	DeadLetter           *DeadLetter `yaml:"dead_letter,omitempty" json:"dead_letter,omitempty"`
+	Lazy                 bool        `yaml:"lazy,omitempty" json:"lazy,omitempty"`
+	SingleActiveConsumer bool        `yaml:"single_active_consumer,omitempty" json:"single_active_consumer,omitempty"`
+	// Retry declares the retry queues a subscription with this policy
+	// parks nacked messages in.
+	Retry    *Retry    `yaml:"retry,omitempty" json:"retry,omitempty"`
+	Bindings []Binding `yaml:"bindings,omitempty" json:"bindings,omitempty"`
+}
+
+type DeadLetter struct {
+	Exchange   string `yaml:"exchange" json:"exchange"`
+	RoutingKey string `yaml:"routing_key,omitempty" json:"routing_key,omitempty"`
+}
+
+type Retry struct {
+	Delays      []Duration `yaml:"delays" json:"delays"`
+	MaxAttempts int        `yaml:"max_attempts" json:"max_attempts"`
+}
+
+type Binding struct {
+	Exchange string `yaml:"exchange" json:"exchange"`
+	Key      string `yaml:"key" json:"key"`
+}
+
+// Duration is a time.Duration written as "1s" or "500ms" in topology files.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func durations(delays []time.Duration) []Duration {
+	converted := make([]Duration, len(delays))
+	for i, delay := range delays {
+		converted[i] = Duration(delay)
+	}
+	return converted
+}
+
+// Peril is the topology the game runs with unless a file says otherwise.
+func Peril() Topology {
+	return Topology{
+		Exchanges: []Exchange{
+			{Name: routing.ExchangePerilDirect, Kind: "direct"},
+			{Name: routing.ExchangePerilTopic, Kind: "topic"},
+			{Name: routing.ExchangePerilDLX, Kind: "fanout"},
+		},
+		Queues: []Queue{
+			{
+				Name:     routing.QueuePerilDLQ,
+				Type:     pubsub.QueueTypeDurable,
+				Bindings: []Binding{{Exchange: routing.ExchangePerilDLX, Key: ""}},
+			},
+			{
+				Name: routing.GameLogSlug,
+				Type: pubsub.QueueTypeDurable,
+				Retry: &Retry{
+					Delays:      durations(pubsub.DefaultRetryPolicy.Delays),
+					MaxAttempts: pubsub.DefaultRetryPolicy.MaxAttempts,
+				},
+				Bindings: []Binding{{Exchange: routing.ExchangePerilTopic, Key: routing.GameLogSlug + ".*"}},
+			},
+			{
+				Name:     routing.IntentsPrefix,
+				Type:     pubsub.QueueTypeDurable,
+				Bindings: []Binding{{Exchange: routing.ExchangePerilTopic, Key: routing.IntentsPrefix + ".*"}},
+			},
+			{
+				Name: routing.EventsPrefix + "." + routing.StatePrefix,
+				Type: pubsub.QueueTypeDurable,
+				Retry: &Retry{
+					Delays:      durations(pubsub.DefaultRetryPolicy.Delays),
+					MaxAttempts: pubsub.DefaultRetryPolicy.MaxAttempts,
+				},
+				Bindings: []Binding{{Exchange: routing.ExchangePerilTopic, Key: routing.StatePrefix + ".*"}},
+			},
+			{
+				Name: routing.EventsPrefix + "." + routing.PauseKey,
+				Type: pubsub.QueueTypeDurable,
+				Retry: &Retry{
+					Delays:      durations(pubsub.DefaultRetryPolicy.Delays),
+					MaxAttempts: pubsub.DefaultRetryPolicy.MaxAttempts,
+				},
+				Bindings: []Binding{{Exchange: routing.ExchangePerilDirect, Key: routing.PauseKey}},
+			},
+			{
+				Name:     routing.DiplomacyPrefix,
+				Type:     pubsub.QueueTypeDurable,
+				Bindings: []Binding{{Exchange: routing.ExchangePerilTopic, Key: routing.DiplomacyKey("*", "*")}},
+			},
+			{
+				Name:     routing.ChatPrefix,
+				Type:     pubsub.QueueTypeDurable,
+				Bindings: []Binding{{Exchange: routing.ExchangePerilTopic, Key: routing.ChatKey(routing.ChatSend, "*")}},
+			},
+			{
+				Name:     routing.PauseKey + "." + UsernamePlaceholder,
+				Type:     pubsub.QueueTypeTransient,
+				Bindings: []Binding{{Exchange: routing.ExchangePerilDirect, Key: routing.PauseKey}},
+			},
+			{
+				Name:     routing.TurnKey + "." + UsernamePlaceholder,
+				Type:     pubsub.QueueTypeTransient,
+				Bindings: []Binding{{Exchange: routing.ExchangePerilDirect, Key: routing.TurnKey}},
+			},
+			{
+				Name:     routing.GameOverKey + "." + UsernamePlaceholder,
+				Type:     pubsub.QueueTypeTransient,
+				Bindings: []Binding{{Exchange: routing.ExchangePerilDirect, Key: routing.GameOverKey}},
+			},
+			{
+				Name: routing.StatePrefix + "." + UsernamePlaceholder,
+				Type: pubsub.QueueTypeTransient,
+				Bindings: []Binding{
+					{Exchange: routing.ExchangePerilTopic, Key: routing.StatePrefix + ".*"},
+					{Exchange: routing.ExchangePerilTopic, Key: routing.PrivateStateKey(UsernamePlaceholder)},
+				},
+			},
+			{
+				Name:     routing.DiplomacyPrefix + "." + UsernamePlaceholder,
+				Type:     pubsub.QueueTypeTransient,
+				Bindings: []Binding{{Exchange: routing.ExchangePerilTopic, Key: routing.DiplomacyKey(UsernamePlaceholder, "*")}},
+			},
+			{
+				Name: routing.ChatPrefix + "." + UsernamePlaceholder,
+				Type: pubsub.QueueTypeTransient,
+				Bindings: []Binding{
+					{Exchange: routing.ExchangePerilTopic, Key: routing.ChatKey(routing.ChatGlobal, "")},
+					{Exchange: routing.ExchangePerilTopic, Key: routing.ChatKey(routing.ChatAlliance, UsernamePlaceholder)},
+					{Exchange: routing.ExchangePerilTopic, Key: routing.ChatKey(routing.ChatDirect, UsernamePlaceholder)},
+				},
+			},
+		},
+	}
+}
+
+// Load reads a topology from a .yaml, .yml or .json file.
+func Load(path string) (Topology, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Topology{}, err
+	}
+
+	var t Topology
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &t)
+	case ".json":
+		err = json.Unmarshal(data, &t)
+	default:
+		return Topology{}, fmt.Errorf("unknown topology format '%s', use .yaml or .json", filepath.Ext(path))
+	}
+	if err != nil {
+		return Topology{}, fmt.Errorf("reading topology '%s': %w", path, err)
+	}
+
+	err = t.Validate()
+	if err != nil {
+		return Topology{}, fmt.Errorf("topology '%s': %w", path, err)
+	}
+
+	return t, nil
+}
+
+// YAML is the topology in the format Load reads back.
+func (t Topology) YAML() ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err := encoder.Encode(t)
+	if err != nil {
+		return nil, err
+	}
+	err = encoder.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (t Topology) Validate() error {
+	errs := []error{}
+	exchanges := map[string]bool{}
+	for _, ex := range t.Exchanges {
+		switch ex.Kind {
+		case "direct", "topic", "fanout", "headers":
+		default:
+			errs = append(errs, fmt.Errorf("exchange '%s' has unknown kind '%s'", ex.Name, ex.Kind))
+		}
+		if ex.Name == "" {
+			errs = append(errs, errors.New("exchange without a name"))
+		}
+		exchanges[ex.Name] = true
+	}
+
+	queues := map[string]bool{}
+	for _, q := range t.Queues {
+		if q.Name == "" {
+			errs = append(errs, errors.New("queue without a name"))
+			continue
+		}
+		if queues[q.Name] {
+			errs = append(errs, fmt.Errorf("queue '%s' is declared twice", q.Name))
+		}
+		queues[q.Name] = true
+
+		err := q.Options().Validate()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("queue '%s': %w", q.Name, err))
+		}
+		if q.Retry != nil && len(q.Retry.Delays) == 0 {
+			errs = append(errs, fmt.Errorf("queue '%s' retries without any delays", q.Name))
+		}
+		for _, b := range q.Bindings {
+			if !exchanges[b.Exchange] {
+				errs = append(errs, fmt.Errorf("queue '%s' is bound to undeclared exchange '%s'", q.Name, b.Exchange))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Queue finds a queue by name. Per-player queues are looked up by their
+// templated name, e.g. "pause.{username}".
+func (t Topology) Queue(name string) (Queue, bool) {
+	for _, q := range t.Queues {
+		if q.Name == name {
+			return q, true
+		}
+	}
+	return Queue{}, false
+}
+
+// Shared leaves out the per-player queues, which only clients declare.
+func (t Topology) Shared() Topology {
+	shared := Topology{Exchanges: t.Exchanges}
+	for _, q := range t.Queues {
+		if !q.Templated() {
+			shared.Queues = append(shared.Queues, q)
+		}
+	}
+	return shared
+}
+
+// ForUser fills in the username of every per-player queue.
+func (t Topology) ForUser(username string) Topology {
+	user := Topology{Exchanges: t.Exchanges}
+	for _, q := range t.Queues {
+		user.Queues = append(user.Queues, q.forUser(username))
+	}
+	return user
+}
+
+// Player is just the per-player queues with username filled in, which is
+// all a player's RabbitMQ user may declare. The exchanges they bind to are
+// declared by the server.
+func (t Topology) Player(username string) Topology {
+	var player Topology
+	for _, q := range t.Queues {
+		if q.Templated() {
+			player.Queues = append(player.Queues, q.forUser(username))
+		}
+	}
+	return player
+}
+
+// Apply declares everything in the topology. Declaring is idempotent, so it
+// is safe to run on every start. Templated queues have to be filled in with
+// ForUser first.
+func (t Topology) Apply(broker pubsub.Broker) error {
+	for _, ex := range t.Exchanges {
+		err := broker.DeclareExchange(ex.Name, ex.Kind)
+		if err != nil {
+			return fmt.Errorf("declaring exchange '%s': %w", ex.Name, err)
+		}
+	}
+
+	for _, q := range t.expanded().Queues {
+		if q.Templated() {
+			return fmt.Errorf("queue '%s' needs a username", q.Name)
+		}
+
+		if len(q.Bindings) == 0 {
+			err := broker.DeclareQueue(q.Name, q.Options())
+			if err != nil {
+				return fmt.Errorf("declaring queue '%s': %w", q.Name, err)
+			}
+			continue
+		}
+		for _, b := range q.Bindings {
+			err := broker.DeclareAndBindQueue(b.Exchange, q.Name, b.Key, q.Options())
+			if err != nil {
+				return fmt.Errorf("declaring queue '%s' bound to '%s' with key '%s': %w", q.Name, b.Exchange, b.Key, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// expanded lists retry queues as queues of their own, the way the broker
+// sees them.
+func (t Topology) expanded() Topology {
+	expanded := Topology{Exchanges: t.Exchanges}
+	for _, q := range t.Queues {
+		expanded.Queues = append(expanded.Queues, q)
+		if q.Retry == nil {
+			continue
+		}
+		for _, delay := range q.Retry.Delays {
+			name, options := pubsub.RetryQueue(q.Name, time.Duration(delay))
+			expanded.Queues = append(expanded.Queues, fromOptions(name, options))
+		}
+	}
+	return expanded
+}
+
+func (q Queue) Templated() bool {
+	return strings.Contains(q.Name, UsernamePlaceholder)
+}
+
+func (q Queue) forUser(username string) Queue {
+	q.Name = strings.ReplaceAll(q.Name, UsernamePlaceholder, username)
+	bindings := make([]Binding, len(q.Bindings))
+	for i, b := range q.Bindings {
+		b.Key = strings.ReplaceAll(b.Key, UsernamePlaceholder, username)
+		bindings[i] = b
+	}
+	q.Bindings = bindings
+	return q
+}
+
+func (q Queue) Options() pubsub.QueueOptions {
+	options := pubsub.QueueOptions{
+		Type:                 q.Type,
+		MessageTTL:           time.Duration(q.MessageTTL),
+		MaxLength:            q.MaxLength,
+		Overflow:             q.Overflow,
+		Lazy:                 q.Lazy,
+		SingleActiveConsumer: q.SingleActiveConsumer,
+	}
+	if q.DeadLetter != nil {
+		options.DeadLetter = &pubsub.DeadLetterTarget{Exchange: q.DeadLetter.Exchange, RoutingKey: q.DeadLetter.RoutingKey}
+	}
+	return options
+}
+
+// SubscribeOptions are the options a subscription to the queue needs to
+// match its declaration.
+func (q Queue) SubscribeOptions() []pubsub.SubscribeOption {
+	if q.Retry == nil {
+		return nil
+	}
+	policy := pubsub.RetryPolicy{MaxAttempts: q.Retry.MaxAttempts}
+	for _, delay := range q.Retry.Delays {
+		policy.Delays = append(policy.Delays, time.Duration(delay))
+	}
+	return []pubsub.SubscribeOption{pubsub.WithRetry(policy)}
+}
+
+func fromOptions(name string, options pubsub.QueueOptions) Queue {
+	q := Queue{
+		Name:                 name,
+		Type:                 options.Type,
+		MessageTTL:           Duration(options.MessageTTL),
+		MaxLength:            options.MaxLength,
+		Overflow:             options.Overflow,
+		Lazy:                 options.Lazy,
+		SingleActiveConsumer: options.SingleActiveConsumer,
+	}
+	if options.DeadLetter != nil {
+		q.DeadLetter = &DeadLetter{Exchange: options.DeadLetter.Exchange, RoutingKey: options.DeadLetter.RoutingKey}
+	}
+	return q
+}
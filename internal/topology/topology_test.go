@@ -0,0 +1,178 @@
+package topology
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestTemplating(t *testing.T) {
+	peril := Peril()
+	if err := peril.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, q := range peril.Shared().Queues {
+		if q.Templated() {
+			t.Errorf("shared topology has per-player queue '%s'", q.Name)
+		}
+	}
+
+	player := peril.Player("alice")
+	if len(player.Exchanges) != 0 {
+		t.Errorf("a player declares exchanges: %v", player.Exchanges)
+	}
+	if len(player.Queues)+len(peril.Shared().Queues) != len(peril.Queues) {
+		t.Errorf("%d player and %d shared queues out of %d", len(player.Queues), len(peril.Shared().Queues), len(peril.Queues))
+	}
+	state, ok := player.Queue(routing.StatePrefix + ".alice")
+	if !ok {
+		t.Fatal("alice has no state queue")
+	}
+	if state.Bindings[1].Key != routing.PrivateStateKey("alice") {
+		t.Errorf("alice's state queue is bound with %v", state.Bindings)
+	}
+	for _, q := range player.Queues {
+		if strings.Contains(q.Name, UsernamePlaceholder) {
+			t.Errorf("queue '%s' wasn't filled in", q.Name)
+		}
+		for _, b := range q.Bindings {
+			if strings.Contains(b.Key, UsernamePlaceholder) {
+				t.Errorf("queue '%s' is bound with key '%s'", q.Name, b.Key)
+			}
+		}
+	}
+
+	// ForUser keeps everything, Player only what the player declares.
+	user := peril.ForUser("alice")
+	if len(user.Queues) != len(peril.Queues) || len(user.Exchanges) != len(peril.Exchanges) {
+		t.Errorf("ForUser has %d queues and %d exchanges", len(user.Queues), len(user.Exchanges))
+	}
+	if _, ok := peril.Queue(routing.PauseKey + "." + UsernamePlaceholder); !ok {
+		t.Error("per-player queues can't be found by their template")
+	}
+	if _, ok := peril.Queue(routing.PauseKey + ".alice"); ok {
+		t.Error("a filled in queue was found in the templated topology")
+	}
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	data, err := Peril().YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "topology.yaml")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, Peril()) {
+		t.Errorf("round trip changed the topology:\n%s", data)
+	}
+
+	// The checked in file is what the topology tool prints.
+	committed, err := Load(filepath.Join("..", "..", "topology.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(committed, Peril()) {
+		t.Error("topology.yaml is out of date, regenerate it with 'go run ./cmd/topology print'")
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"kind.yaml":     "exchanges: [{name: x, kind: weird}]\n",
+		"unbound.yaml":  "queues: [{name: q, type: durable, bindings: [{exchange: nowhere, key: k}]}]\n",
+		"twice.yaml":    "queues: [{name: q, type: durable}, {name: q, type: durable}]\n",
+		"retry.yaml":    "queues: [{name: q, type: durable, retry: {max_attempts: 3}}]\n",
+		"duration.json": `{"queues": [{"name": "q", "type": "durable", "message_ttl": "soon"}]}`,
+		"format.toml":   "",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); err == nil {
+			t.Errorf("%s loaded", name)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	broker := pubsub.NewMemoryBroker()
+	defer broker.Close()
+
+	if err := Peril().Player("alice").Apply(broker); err == nil {
+		t.Fatal("player queues were declared before the server's exchanges")
+	}
+	if err := Peril().Apply(broker); err == nil {
+		t.Fatal("per-player queues were declared without a username")
+	}
+	if err := Peril().Shared().Apply(broker); err != nil {
+		t.Fatal(err)
+	}
+	if err := Peril().Player("alice").Apply(broker); err != nil {
+		t.Fatal(err)
+	}
+	if err := Peril().Player("bob").Apply(broker); err != nil {
+		t.Fatal(err)
+	}
+
+	publish := func(key string) {
+		t.Helper()
+		err := broker.Publish(context.Background(), routing.ExchangePerilTopic, key, amqp.Publishing{Body: []byte("{}")})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish(routing.StatePrefix + ".public")
+	publish(routing.PrivateStateKey("alice"))
+
+	if n := broker.QueueLength(routing.StatePrefix + ".alice"); n != 2 {
+		t.Errorf("alice got %d state deltas, want 2", n)
+	}
+	if n := broker.QueueLength(routing.StatePrefix + ".bob"); n != 1 {
+		t.Errorf("bob got %d state deltas, want only the public one", n)
+	}
+	if n := broker.QueueLength(routing.EventsPrefix + "." + routing.StatePrefix); n != 1 {
+		t.Errorf("the event log got %d state deltas, want only the public one", n)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	desired := Peril()
+	// What Inspect would find on a broker with the server and alice running.
+	actual := Topology{Exchanges: desired.Exchanges}
+	for _, q := range append(desired.Shared().expanded().Queues, desired.Player("alice").Queues...) {
+		actual.Queues = append(actual.Queues, q.withDefaults())
+	}
+	if lines := Diff(desired, actual); len(lines) != 0 {
+		t.Fatalf("an up to date broker differs: %v", lines)
+	}
+
+	chat := len(actual.Queues) - 1
+	actual.Queues[chat].Bindings = actual.Queues[chat].Bindings[1:]
+	actual.Queues = append(actual.Queues, Queue{Name: "stray", Type: pubsub.QueueTypeDurable})
+	actual.Exchanges = actual.Exchanges[1:]
+
+	want := []string{
+		"+ exchange 'peril_direct' (direct)",
+		"+ binding 'peril_topic' -> 'chat.alice' with key 'chat.global'",
+		"- queue 'stray' (durable, no dead-lettering)",
+	}
+	if lines := Diff(desired, actual); !reflect.DeepEqual(lines, want) {
+		t.Errorf("diff:\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
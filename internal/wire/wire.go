@@ -0,0 +1,151 @@
+// Package wire holds the protobuf schemas for game messages and registers
+// their conversions with pubsub.ProtobufCodec. Import it for its side
+// effects wherever game messages are published or consumed as protobuf.
+package wire
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative peril.proto
+
+import (
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func init() {
+	pubsub.RegisterProtoMapping(ArmyMoveToProto, ArmyMoveFromProto)
+	pubsub.RegisterProtoMapping(RecognitionOfWarToProto, RecognitionOfWarFromProto)
+	pubsub.RegisterProtoMapping(PlayingStateToProto, PlayingStateFromProto)
+	pubsub.RegisterProtoMapping(GameLogToProto, GameLogFromProto)
+	pubsub.RegisterProtoMapping(IntentToProto, IntentFromProto)
+}
+
+func UnitToProto(u gamelogic.Unit) *Unit {
+	return &Unit{
+		Id:       int32(u.ID),
+		Rank:     string(u.Rank),
+		Location: string(u.Location),
+	}
+}
+
+func UnitFromProto(u *Unit) gamelogic.Unit {
+	return gamelogic.Unit{
+		ID:       int(u.GetId()),
+		Rank:     gamelogic.UnitRank(u.GetRank()),
+		Location: gamelogic.Location(u.GetLocation()),
+	}
+}
+
+func PlayerToProto(p gamelogic.Player) *Player {
+	units := make(map[int32]*Unit, len(p.Units))
+	for id, unit := range p.Units {
+		units[int32(id)] = UnitToProto(unit)
+	}
+	return &Player{
+		Username: p.Username,
+		Units:    units,
+	}
+}
+
+func PlayerFromProto(p *Player) gamelogic.Player {
+	units := make(map[int]gamelogic.Unit, len(p.GetUnits()))
+	for id, unit := range p.GetUnits() {
+		units[int(id)] = UnitFromProto(unit)
+	}
+	return gamelogic.Player{
+		Username: p.GetUsername(),
+		Units:    units,
+	}
+}
+
+func ArmyMoveToProto(mv gamelogic.ArmyMove) *ArmyMove {
+	units := make([]*Unit, 0, len(mv.Units))
+	for _, unit := range mv.Units {
+		units = append(units, UnitToProto(unit))
+	}
+	return &ArmyMove{
+		Player:     PlayerToProto(mv.Player),
+		Units:      units,
+		ToLocation: string(mv.ToLocation),
+	}
+}
+
+func ArmyMoveFromProto(mv *ArmyMove) gamelogic.ArmyMove {
+	units := make([]gamelogic.Unit, 0, len(mv.GetUnits()))
+	for _, unit := range mv.GetUnits() {
+		units = append(units, UnitFromProto(unit))
+	}
+	return gamelogic.ArmyMove{
+		Player:     PlayerFromProto(mv.GetPlayer()),
+		Units:      units,
+		ToLocation: gamelogic.Location(mv.GetToLocation()),
+	}
+}
+
+func RecognitionOfWarToProto(rw gamelogic.RecognitionOfWar) *RecognitionOfWar {
+	return &RecognitionOfWar{
+		Attacker: PlayerToProto(rw.Attacker),
+		Defender: PlayerToProto(rw.Defender),
+	}
+}
+
+func RecognitionOfWarFromProto(rw *RecognitionOfWar) gamelogic.RecognitionOfWar {
+	return gamelogic.RecognitionOfWar{
+		Attacker: PlayerFromProto(rw.GetAttacker()),
+		Defender: PlayerFromProto(rw.GetDefender()),
+	}
+}
+
+func PlayingStateToProto(ps routing.PlayingState) *PlayingState {
+	return &PlayingState{IsPaused: ps.IsPaused}
+}
+
+func PlayingStateFromProto(ps *PlayingState) routing.PlayingState {
+	return routing.PlayingState{IsPaused: ps.GetIsPaused()}
+}
+
+func GameLogToProto(gl routing.GameLog) *GameLog {
+	return &GameLog{
+		CurrentTime: timestamppb.New(gl.CurrentTime),
+		Message:     gl.Message,
+		Username:    gl.Username,
+	}
+}
+
+func GameLogFromProto(gl *GameLog) routing.GameLog {
+	return routing.GameLog{
+		CurrentTime: gl.GetCurrentTime().AsTime(),
+		Message:     gl.GetMessage(),
+		Username:    gl.GetUsername(),
+	}
+}
+
+func IntentToProto(in gamelogic.Intent) *Intent {
+	unitIDs := make([]int32, 0, len(in.UnitIDs))
+	for _, id := range in.UnitIDs {
+		unitIDs = append(unitIDs, int32(id))
+	}
+	return &Intent{
+		Id:       in.ID,
+		Kind:     string(in.Kind),
+		Username: in.Username,
+		Rank:     string(in.Rank),
+		Location: string(in.Location),
+		UnitIds:  unitIDs,
+	}
+}
+
+func IntentFromProto(in *Intent) gamelogic.Intent {
+	var unitIDs []int
+	for _, id := range in.GetUnitIds() {
+		unitIDs = append(unitIDs, int(id))
+	}
+	return gamelogic.Intent{
+		ID:       in.GetId(),
+		Kind:     gamelogic.IntentKind(in.GetKind()),
+		Username: in.GetUsername(),
+		Rank:     gamelogic.UnitRank(in.GetRank()),
+		Location: gamelogic.Location(in.GetLocation()),
+		UnitIDs:  unitIDs,
+	}
+}
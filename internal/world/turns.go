@@ -0,0 +1,156 @@
+package world
+
+import (
+	"fmt"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+)
+
+// Turn is the current turn, 0 while turns are off.
+func (w *World) Turn() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.turn
+}
+
+// StartTurns switches to turn mode, starting at turn 1, and returns the
+// current turn.
+func (w *World) StartTurns() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.turn == 0 {
+		w.turn = 1
+	}
+	return w.turn
+}
+
+// EndTurn carries out every order of the turn, pays income for what
+// everyone holds afterwards and starts the next one. Orders all take effect
+// before any war is fought, so every unit moves from where it was when the
+// turn started. Once the game is over nothing is carried out.
+func (w *World) EndTurn() []gamelogic.StateDelta {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.turn == 0 || w.over != nil {
+		return nil
+	}
+	deltas := w.resolve()
+	w.turn++
+	return deltas
+}
+
+// StopTurns carries out the orders of the current turn and goes back to
+// carrying out intents as they arrive. Once the game is over the orders
+// are dropped instead.
+func (w *World) StopTurns() []gamelogic.StateDelta {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.turn == 0 {
+		return nil
+	}
+	if w.over != nil {
+		w.orders = nil
+		w.ordered = map[string]map[int]bool{}
+		w.reserved = map[string]int{}
+		w.turn = 0
+		return nil
+	}
+	deltas := w.resolve()
+	w.turn = 0
+	return deltas
+}
+
+// order checks a spawn, move or fortification against the world as it is
+// now and keeps it for the end of the turn.
+func (w *World) order(intent gamelogic.Intent) ([]gamelogic.StateDelta, error) {
+	delta := gamelogic.StateDelta{
+		Kind:     gamelogic.DeltaOrdered,
+		Username: intent.Username,
+		Location: intent.Location,
+		Turn:     w.turn,
+	}
+	switch intent.Kind {
+	case gamelogic.IntentSpawn:
+		err := w.checkSpawn(intent)
+		if err != nil {
+			return nil, err
+		}
+		w.reserved[intent.Username] += w.board.Cost(intent.Rank)
+		delta.Units = []gamelogic.Unit{{Rank: intent.Rank, Location: intent.Location}}
+	case gamelogic.IntentMove:
+		moved, err := w.checkMove(intent)
+		if err != nil {
+			return nil, err
+		}
+		for _, unit := range moved {
+			if w.ordered[intent.Username][unit.ID] {
+				return nil, fmt.Errorf("unit %v already has orders this turn", unit.ID)
+			}
+		}
+		if w.ordered[intent.Username] == nil {
+			w.ordered[intent.Username] = map[int]bool{}
+		}
+		for _, unit := range moved {
+			w.ordered[intent.Username][unit.ID] = true
+		}
+		delta.Units = moved
+	case gamelogic.IntentFortify:
+		err := w.checkFortify(intent)
+		if err != nil {
+			return nil, err
+		}
+		w.reserved[intent.Username] += w.board.FortCost
+	}
+
+	w.orders = append(w.orders, intent)
+	return []gamelogic.StateDelta{delta}, nil
+}
+
+func (w *World) resolve() []gamelogic.StateDelta {
+	orders := w.orders
+	w.orders = nil
+	w.ordered = map[string]map[int]bool{}
+	w.reserved = map[string]int{}
+
+	// A paused game keeps its orders, they were fine when they were given.
+	paused := w.paused
+	w.paused = false
+	defer func() { w.paused = paused }()
+
+	deltas := []gamelogic.StateDelta{}
+	arrived := []gamelogic.Intent{}
+	for _, intent := range orders {
+		var delta gamelogic.StateDelta
+		var err error
+		switch intent.Kind {
+		case gamelogic.IntentSpawn:
+			delta, err = w.spawn(intent)
+		case gamelogic.IntentMove:
+			delta, err = w.move(intent)
+		case gamelogic.IntentFortify:
+			delta, err = w.fortify(intent)
+		}
+		if err != nil {
+			delta = rejected(intent, err)
+		} else if intent.Kind != gamelogic.IntentFortify {
+			arrived = append(arrived, intent)
+		}
+		delta.IntentID = intent.ID
+		deltas = append(deltas, delta)
+	}
+	for _, intent := range arrived {
+		for _, delta := range w.fight(w.player(intent.Username), intent.Location) {
+			delta.IntentID = intent.ID
+			deltas = append(deltas, delta)
+		}
+	}
+	deltas = append(deltas, w.fightContested()...)
+	deltas = append(deltas, w.claim()...)
+	deltas = append(deltas, w.payIncome()...)
+	deltas = append(deltas, w.settle()...)
+
+	for i := range deltas {
+		deltas[i].Turn = w.turn
+	}
+	return w.number(deltas, "")
+}
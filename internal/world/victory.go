@@ -0,0 +1,162 @@
+package world
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// Victory is how a game can be won. Zero values switch a condition off.
+type Victory struct {
+	// Territories wins the game for the first player to hold this many.
+	Territories int
+	// LastStanding wins the game for the only player left once everyone
+	// else who fielded units has been eliminated.
+	LastStanding bool
+	// TimeLimit ends the game after this long, won by whoever stands best.
+	TimeLimit time.Duration
+}
+
+var DefaultVictory = Victory{LastStanding: true}
+
+func (w *World) SetVictory(victory Victory) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.victory = victory
+}
+
+// TakeGameOver returns how the game ended, but only the first time it is
+// called after the game is over, so it is announced once.
+func (w *World) TakeGameOver() (routing.GameOver, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.over == nil || w.overTaken {
+		return routing.GameOver{}, false
+	}
+	w.overTaken = true
+	return *w.over, true
+}
+
+// EndByScore ends the game in favour of whoever stands best, like when the
+// time limit is up.
+func (w *World) EndByScore(reason string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.over != nil {
+		return
+	}
+	standings := w.standings()
+	winner := ""
+	if len(standings) > 0 && !standings[0].Eliminated {
+		winner = standings[0].Username
+		// Nobody wins if the best two can't be told apart.
+		if len(standings) > 1 && sameStanding(standings[0], standings[1]) {
+			winner = ""
+		}
+	}
+	w.end(winner, reason)
+}
+
+// Standings are every player, best first: most territories, then most
+// power, then most gold.
+func (w *World) Standings() []routing.Standing {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.standings()
+}
+
+func (w *World) Eliminated(username string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.eliminated[username]
+}
+
+func (w *World) standings() []routing.Standing {
+	standings := []routing.Standing{}
+	for _, username := range w.usernames() {
+		player := *w.players[username]
+		standings = append(standings, routing.Standing{
+			Username:    username,
+			Territories: gamelogic.Territories(username, w.territories),
+			Power:       gamelogic.PowerOf(player),
+			Gold:        w.treasury[username],
+			Eliminated:  w.eliminated[username],
+		})
+	}
+	sort.SliceStable(standings, func(i, j int) bool {
+		a, b := standings[i], standings[j]
+		if a.Eliminated != b.Eliminated {
+			return !a.Eliminated
+		}
+		if a.Territories != b.Territories {
+			return a.Territories > b.Territories
+		}
+		if a.Power != b.Power {
+			return a.Power > b.Power
+		}
+		return a.Gold > b.Gold
+	})
+	return standings
+}
+
+func sameStanding(a, b routing.Standing) bool {
+	return a.Eliminated == b.Eliminated && a.Territories == b.Territories && a.Power == b.Power && a.Gold == b.Gold
+}
+
+// settle eliminates players who can't fight on and checks whether anyone
+// has won. It runs after anything that can change who holds what.
+func (w *World) settle() []gamelogic.StateDelta {
+	if w.over != nil {
+		return nil
+	}
+
+	deltas := []gamelogic.StateDelta{}
+	standing := []string{}
+	fielded := 0
+	for _, username := range w.usernames() {
+		player := w.players[username]
+		if len(player.Units) > 0 {
+			w.fielded[username] = true
+		}
+		if !w.fielded[username] {
+			continue
+		}
+		fielded++
+		if !w.eliminated[username] && len(player.Units) == 0 && w.treasury[username] < w.board.CheapestCost() {
+			w.eliminated[username] = true
+			deltas = append(deltas, gamelogic.StateDelta{
+				Kind:     gamelogic.DeltaEliminated,
+				Username: username,
+				Balance:  w.treasury[username],
+			})
+		}
+		if !w.eliminated[username] {
+			standing = append(standing, username)
+		}
+	}
+
+	if w.victory.Territories > 0 {
+		for _, username := range standing {
+			held := gamelogic.Territories(username, w.territories)
+			if held >= w.victory.Territories {
+				w.end(username, fmt.Sprintf("%s holds %d territories", username, held))
+				return deltas
+			}
+		}
+	}
+	if w.victory.LastStanding && fielded >= 2 {
+		if len(standing) == 1 {
+			w.end(standing[0], fmt.Sprintf("%s is the last player standing", standing[0]))
+		} else if len(standing) == 0 {
+			w.end("", "everyone has been eliminated")
+		}
+	}
+	return deltas
+}
+
+func (w *World) end(winner, reason string) {
+	w.over = &routing.GameOver{Winner: winner, Reason: reason, Standings: w.standings()}
+}
@@ -0,0 +1,128 @@
+package world
+
+import (
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+)
+
+func join(t *testing.T, w *World, usernames ...string) {
+	t.Helper()
+	for _, username := range usernames {
+		handle(t, w, gamelogic.Intent{Kind: gamelogic.IntentJoin, Username: username})
+	}
+}
+
+func unitIDs(w *World, username string) []int {
+	ids := []int{}
+	for _, player := range w.Players() {
+		if player.Username != username {
+			continue
+		}
+		for id := range player.Units {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func TestLastStanding(t *testing.T) {
+	w := New(gamelogic.DefaultMap())
+	join(t, w, "alice", "bob")
+
+	handle(t, w, spawn("alice", gamelogic.RankArtillery, "europe"))
+	handle(t, w, spawn("alice", gamelogic.RankInfantry, "europe"))
+	handle(t, w, spawn("bob", gamelogic.RankArtillery, "asia"))
+	handle(t, w, spawn("bob", gamelogic.RankInfantry, "asia"))
+	handle(t, w, spawn("bob", gamelogic.RankInfantry, "asia"))
+	if _, over := w.TakeGameOver(); over {
+		t.Fatal("the game ended before anyone fought")
+	}
+
+	// Bob attacks with everything and has too little gold left to go on.
+	deltas := handle(t, w, gamelogic.Intent{Kind: gamelogic.IntentMove, Username: "bob", Location: "europe", UnitIDs: unitIDs(w, "bob")})
+	eliminated := false
+	for _, delta := range deltas {
+		eliminated = eliminated || delta.Kind == gamelogic.DeltaEliminated && delta.Username == "bob"
+	}
+	if !eliminated || !w.Eliminated("bob") {
+		t.Fatalf("bob wasn't eliminated: %+v", deltas)
+	}
+	over, ok := w.TakeGameOver()
+	if !ok || over.Winner != "alice" {
+		t.Fatalf("game over: %+v, %v", over, ok)
+	}
+	if _, again := w.TakeGameOver(); again {
+		t.Error("the game over was handed out twice")
+	}
+	if len(over.Standings) != 2 || over.Standings[0].Username != "alice" || !over.Standings[1].Eliminated {
+		t.Errorf("standings: %+v", over.Standings)
+	}
+
+	deltas = w.Handle(spawn("alice", gamelogic.RankInfantry, "asia"))
+	if len(deltas) != 1 || deltas[0].Kind != gamelogic.DeltaRejected {
+		t.Errorf("alice spawned after the game ended: %+v", deltas)
+	}
+	if deltas := w.PayIncome(); len(deltas) != 0 {
+		t.Errorf("income was paid after the game ended: %+v", deltas)
+	}
+}
+
+func TestTerritoryVictory(t *testing.T) {
+	w := New(gamelogic.DefaultMap())
+	w.SetVictory(Victory{Territories: 2})
+	join(t, w, "alice")
+
+	handle(t, w, spawn("alice", gamelogic.RankInfantry, "europe"))
+	if _, over := w.TakeGameOver(); over {
+		t.Fatal("one territory won the game")
+	}
+	handle(t, w, spawn("alice", gamelogic.RankInfantry, "asia"))
+	over, ok := w.TakeGameOver()
+	if !ok || over.Winner != "alice" {
+		t.Fatalf("game over: %+v, %v", over, ok)
+	}
+}
+
+func TestEndByScore(t *testing.T) {
+	w := New(gamelogic.DefaultMap())
+	join(t, w, "alice", "bob")
+	handle(t, w, spawn("alice", gamelogic.RankInfantry, "europe"))
+	handle(t, w, spawn("bob", gamelogic.RankInfantry, "asia"))
+
+	tied := New(gamelogic.DefaultMap())
+	tied.Restore(w.Snapshot())
+	tied.EndByScore("time is up")
+	if over, ok := tied.TakeGameOver(); !ok || over.Winner != "" {
+		t.Errorf("a tie was won: %+v", over)
+	}
+
+	handle(t, w, spawn("bob", gamelogic.RankInfantry, "africa"))
+	w.EndByScore("time is up")
+	over, ok := w.TakeGameOver()
+	if !ok || over.Winner != "bob" || over.Reason != "time is up" {
+		t.Errorf("game over: %+v", over)
+	}
+}
+
+func TestGameOverEndsTurns(t *testing.T) {
+	w := New(gamelogic.DefaultMap())
+	join(t, w, "alice", "bob")
+	handle(t, w, spawn("alice", gamelogic.RankInfantry, "europe"))
+	w.StartTurns()
+	handle(t, w, spawn("alice", gamelogic.RankInfantry, "asia"))
+
+	w.EndByScore("time is up")
+	if deltas := w.EndTurn(); len(deltas) != 0 {
+		t.Errorf("a turn was resolved after the game ended: %+v", deltas)
+	}
+	if deltas := w.StopTurns(); len(deltas) != 0 {
+		t.Errorf("stopping turns resolved the orders: %+v", deltas)
+	}
+	if w.Turn() != 0 {
+		t.Errorf("still on turn %d", w.Turn())
+	}
+	if n := len(unitIDs(w, "alice")); n != 1 {
+		t.Errorf("alice has %d units, the order after the game ended was carried out", n)
+	}
+}
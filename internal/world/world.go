@@ -0,0 +1,703 @@
+// Package world is the server's model of every player and unit. Clients only
+// send intents, the world checks them against the game rules, fights the
+// wars they start and returns the deltas clients apply to their own state.
+package world
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+type World struct {
+	mu      sync.Mutex
+	board   *gamelogic.Map
+	players map[string]*gamelogic.Player
+	nextID  map[string]int
+	// treasury is the gold of every player, reserved what their spawn
+	// orders of this turn will cost.
+	treasury map[string]int
+	reserved map[string]int
+	// territories is who controls every territory anyone has taken.
+	territories map[gamelogic.Location]gamelogic.Territory
+	// pacts keep players who made them from fighting each other.
+	pacts  *gamelogic.Pacts
+	paused bool
+	seq    uint64
+	// turn is 0 while intents are carried out as they arrive. In turn mode
+	// spawns and moves wait in orders until the turn ends.
+	turn    int
+	orders  []gamelogic.Intent
+	ordered map[string]map[int]bool
+	victory Victory
+	combat  gamelogic.CombatResolver
+	// fielded is everyone who ever had units, only they can be eliminated.
+	fielded    map[string]bool
+	eliminated map[string]bool
+	over       *routing.GameOver
+	overTaken  bool
+}
+
+func New(board *gamelogic.Map) *World {
+	return &World{
+		board:       board,
+		players:     map[string]*gamelogic.Player{},
+		nextID:      map[string]int{},
+		treasury:    map[string]int{},
+		reserved:    map[string]int{},
+		ordered:     map[string]map[int]bool{},
+		territories: map[gamelogic.Location]gamelogic.Territory{},
+		pacts:       gamelogic.NewPacts(),
+		victory:     DefaultVictory,
+		combat:      gamelogic.ClassicResolver{},
+		fielded:     map[string]bool{},
+		eliminated:  map[string]bool{},
+	}
+}
+
+// SetCombat picks how wars are decided, the classic rule by default.
+func (w *World) SetCombat(combat gamelogic.CombatResolver) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.combat = combat
+}
+
+func (w *World) SetPaused(paused bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused = paused
+}
+
+// Snapshot is everything needed to bring a world back after a restart.
+type Snapshot struct {
+	Players     []gamelogic.Player
+	NextIDs     map[string]int
+	Treasury    map[string]int
+	Territories []gamelogic.Territory
+	Pacts       []gamelogic.Pact
+	Paused      bool
+	Seq         uint64
+	Fielded     []string
+	Eliminated  []string
+	GameOver    *routing.GameOver
+}
+
+func (w *World) Snapshot() Snapshot {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	snap := Snapshot{NextIDs: map[string]int{}, Treasury: map[string]int{}, Paused: w.paused, Seq: w.seq}
+	for _, username := range w.usernames() {
+		snap.Players = append(snap.Players, copyPlayer(w.players[username]))
+	}
+	for username, id := range w.nextID {
+		snap.NextIDs[username] = id
+	}
+	for username, gold := range w.treasury {
+		snap.Treasury[username] = gold
+	}
+	snap.Territories = w.board.SortedTerritories(w.territories)
+	snap.Pacts = w.pacts.Active(time.Now())
+	for _, username := range w.usernames() {
+		if w.fielded[username] {
+			snap.Fielded = append(snap.Fielded, username)
+		}
+		if w.eliminated[username] {
+			snap.Eliminated = append(snap.Eliminated, username)
+		}
+	}
+	snap.GameOver = w.over
+	return snap
+}
+
+// Restore replaces the world with a snapshot and returns a DeltaSnapshot for
+// every player so clients can catch up.
+func (w *World) Restore(snap Snapshot) []gamelogic.StateDelta {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.players = map[string]*gamelogic.Player{}
+	w.nextID = map[string]int{}
+	w.treasury = map[string]int{}
+	w.reserved = map[string]int{}
+	for _, player := range snap.Players {
+		restored := copyPlayer(&player)
+		if restored.Units == nil {
+			restored.Units = map[int]gamelogic.Unit{}
+		}
+		w.players[player.Username] = &restored
+		// Snapshots from before there was gold start everyone over.
+		gold, ok := snap.Treasury[player.Username]
+		if !ok {
+			gold = w.board.StartingGold
+		}
+		w.treasury[player.Username] = gold
+		for id := range restored.Units {
+			if id > w.nextID[player.Username] {
+				w.nextID[player.Username] = id
+			}
+		}
+	}
+	for username, id := range snap.NextIDs {
+		if id > w.nextID[username] {
+			w.nextID[username] = id
+		}
+	}
+	w.territories = map[gamelogic.Location]gamelogic.Territory{}
+	for _, territory := range snap.Territories {
+		// A territory the map doesn't have anymore can't be held.
+		if w.board.HasLocation(territory.Location) {
+			w.territories[territory.Location] = territory
+		}
+	}
+	w.pacts.Replace(snap.Pacts)
+	w.paused = snap.Paused
+	w.fielded = map[string]bool{}
+	for _, username := range snap.Fielded {
+		w.fielded[username] = true
+	}
+	w.eliminated = map[string]bool{}
+	for _, username := range snap.Eliminated {
+		w.eliminated[username] = true
+	}
+	// A game that was over is announced again.
+	w.over = snap.GameOver
+	w.overTaken = false
+	w.orders = nil
+	w.ordered = map[string]map[int]bool{}
+	if snap.Seq > w.seq {
+		w.seq = snap.Seq
+	}
+
+	deltas := []gamelogic.StateDelta{}
+	for _, username := range w.usernames() {
+		w.seq++
+		deltas = append(deltas, gamelogic.StateDelta{
+			Seq:         w.seq,
+			Kind:        gamelogic.DeltaSnapshot,
+			Username:    username,
+			Units:       sortedUnits(w.players[username].Units),
+			Balance:     w.treasury[username],
+			Territories: w.board.SortedTerritories(w.territories),
+		})
+	}
+	return deltas
+}
+
+// Diplomacy applies a diplomatic message between players and returns the
+// pact it made or broke, if any.
+func (w *World) Diplomacy(msg routing.Diplomacy) (gamelogic.Pact, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.pacts.Apply(msg, time.Now())
+}
+
+// Pacts is every pact in force.
+func (w *World) Pacts() []gamelogic.Pact {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.pacts.Active(time.Now())
+}
+
+// Territories is every territory someone controls, in the order of the map.
+func (w *World) Territories() []gamelogic.Territory {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.board.SortedTerritories(w.territories)
+}
+
+// Players is a copy of every player, sorted by username.
+func (w *World) Players() []gamelogic.Player {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	players := make([]gamelogic.Player, 0, len(w.players))
+	for _, username := range w.usernames() {
+		players = append(players, copyPlayer(w.players[username]))
+	}
+	return players
+}
+
+// Balance is the gold a player has.
+func (w *World) Balance(username string) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.treasury[username]
+}
+
+// PayIncome pays every player for the territories they hold. It is for
+// real-time games and does nothing while paused, once the game is over or
+// in turn mode, where income is paid at the end of every turn instead.
+func (w *World) PayIncome() []gamelogic.StateDelta {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.paused || w.turn > 0 || w.over != nil {
+		return nil
+	}
+	return w.number(w.payIncome(), "")
+}
+
+func (w *World) payIncome() []gamelogic.StateDelta {
+	deltas := []gamelogic.StateDelta{}
+	for _, username := range w.usernames() {
+		income := w.board.IncomeOf(username, w.territories)
+		if income == 0 {
+			continue
+		}
+		w.treasury[username] += income
+		deltas = append(deltas, gamelogic.StateDelta{
+			Kind:     gamelogic.DeltaIncome,
+			Username: username,
+			Gold:     income,
+			Balance:  w.treasury[username],
+		})
+	}
+	return deltas
+}
+
+// Handle applies an intent and returns what changed. A refused intent only
+// yields a DeltaRejected for its player.
+func (w *World) Handle(intent gamelogic.Intent) []gamelogic.StateDelta {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if intent.Username == "" {
+		return nil
+	}
+
+	var deltas []gamelogic.StateDelta
+	var err error
+	switch intent.Kind {
+	case gamelogic.IntentJoin:
+		deltas = w.join(intent)
+	case gamelogic.IntentSpawn, gamelogic.IntentMove, gamelogic.IntentFortify:
+		if w.over != nil {
+			err = fmt.Errorf("the game is over, no more orders")
+		} else if w.eliminated[intent.Username] {
+			err = fmt.Errorf("you have been eliminated")
+		} else if w.turn == 0 {
+			deltas, err = w.carryOut(intent)
+			deltas = append(deltas, w.settle()...)
+		} else {
+			deltas, err = w.order(intent)
+		}
+	default:
+		err = fmt.Errorf("unknown intent '%s'", intent.Kind)
+	}
+	if err != nil {
+		deltas = []gamelogic.StateDelta{rejected(intent, err)}
+	}
+
+	return w.number(deltas, intent.ID)
+}
+
+// number gives out sequence numbers and marks deltas without an intent of
+// their own as caused by intentID.
+func (w *World) number(deltas []gamelogic.StateDelta, intentID string) []gamelogic.StateDelta {
+	for i := range deltas {
+		w.seq++
+		deltas[i].Seq = w.seq
+		if deltas[i].IntentID == "" {
+			deltas[i].IntentID = intentID
+		}
+	}
+	return deltas
+}
+
+func rejected(intent gamelogic.Intent, err error) gamelogic.StateDelta {
+	return gamelogic.StateDelta{
+		Kind:     gamelogic.DeltaRejected,
+		Username: intent.Username,
+		IntentID: intent.ID,
+		Reason:   err.Error(),
+	}
+}
+
+// carryOut applies a spawn, move or fortification, fights the wars it
+// starts and hands over the territories that changed hands.
+func (w *World) carryOut(intent gamelogic.Intent) ([]gamelogic.StateDelta, error) {
+	var delta gamelogic.StateDelta
+	var err error
+	switch intent.Kind {
+	case gamelogic.IntentSpawn:
+		delta, err = w.spawn(intent)
+	case gamelogic.IntentMove:
+		delta, err = w.move(intent)
+	case gamelogic.IntentFortify:
+		delta, err = w.fortify(intent)
+		if err != nil {
+			return nil, err
+		}
+		return []gamelogic.StateDelta{delta}, nil
+	default:
+		err = fmt.Errorf("unknown intent '%s'", intent.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	deltas := append([]gamelogic.StateDelta{delta}, w.fight(w.player(intent.Username), intent.Location)...)
+	deltas = append(deltas, w.fightContested()...)
+	return append(deltas, w.claim()...), nil
+}
+
+func (w *World) player(username string) *gamelogic.Player {
+	player, ok := w.players[username]
+	if !ok {
+		player = &gamelogic.Player{Username: username, Units: map[int]gamelogic.Unit{}}
+		w.players[username] = player
+		w.treasury[username] = w.board.StartingGold
+	}
+	return player
+}
+
+func (w *World) join(intent gamelogic.Intent) []gamelogic.StateDelta {
+	player := w.player(intent.Username)
+	return []gamelogic.StateDelta{{
+		Kind:        gamelogic.DeltaSnapshot,
+		Username:    player.Username,
+		Units:       sortedUnits(player.Units),
+		Balance:     w.treasury[player.Username],
+		Territories: w.board.SortedTerritories(w.territories),
+	}}
+}
+
+func (w *World) checkSpawn(intent gamelogic.Intent) error {
+	if w.paused {
+		return fmt.Errorf("the game is paused, you can not spawn units")
+	}
+	err := w.board.ValidateLocation(intent.Location)
+	if err != nil {
+		return err
+	}
+	err = gamelogic.ValidateRank(intent.Rank)
+	if err != nil {
+		return err
+	}
+	// Makes sure someone spawning before they joined gets their starting
+	// gold.
+	w.player(intent.Username)
+	err = w.checkPeace(intent.Username, intent.Location)
+	if err != nil {
+		return err
+	}
+	return w.board.CheckFunds(w.treasury[intent.Username]-w.reserved[intent.Username], intent.Rank)
+}
+
+func (w *World) spawn(intent gamelogic.Intent) (gamelogic.StateDelta, error) {
+	err := w.checkSpawn(intent)
+	if err != nil {
+		return gamelogic.StateDelta{}, err
+	}
+
+	player := w.player(intent.Username)
+	w.nextID[player.Username]++
+	unit := gamelogic.Unit{
+		ID:       w.nextID[player.Username],
+		Rank:     intent.Rank,
+		Location: intent.Location,
+	}
+	player.Units[unit.ID] = unit
+	cost := w.board.Cost(unit.Rank)
+	w.treasury[player.Username] -= cost
+
+	return gamelogic.StateDelta{
+		Kind:     gamelogic.DeltaSpawned,
+		Username: player.Username,
+		Units:    []gamelogic.Unit{unit},
+		Location: unit.Location,
+		Gold:     -cost,
+		Balance:  w.treasury[player.Username],
+	}, nil
+}
+
+// checkMove returns the units of a move as they would be once they got
+// there.
+func (w *World) checkMove(intent gamelogic.Intent) ([]gamelogic.Unit, error) {
+	if w.paused {
+		return nil, fmt.Errorf("the game is paused, you can not move units")
+	}
+	err := w.board.ValidateLocation(intent.Location)
+	if err != nil {
+		return nil, err
+	}
+	if len(intent.UnitIDs) == 0 {
+		return nil, fmt.Errorf("no units to move")
+	}
+	err = w.checkPeace(intent.Username, intent.Location)
+	if err != nil {
+		return nil, err
+	}
+
+	player := w.player(intent.Username)
+	moved := []gamelogic.Unit{}
+	for _, id := range intent.UnitIDs {
+		unit, ok := player.Units[id]
+		if !ok {
+			return nil, fmt.Errorf("error: unit with ID %v not found", id)
+		}
+		err := w.board.ValidateMove(unit, intent.Location)
+		if err != nil {
+			return nil, err
+		}
+		unit.Location = intent.Location
+		moved = append(moved, unit)
+	}
+	return moved, nil
+}
+
+func (w *World) move(intent gamelogic.Intent) (gamelogic.StateDelta, error) {
+	moved, err := w.checkMove(intent)
+	if err != nil {
+		return gamelogic.StateDelta{}, err
+	}
+	player := w.player(intent.Username)
+	for _, unit := range moved {
+		player.Units[unit.ID] = unit
+	}
+
+	return gamelogic.StateDelta{
+		Kind:     gamelogic.DeltaMoved,
+		Username: player.Username,
+		Units:    moved,
+		Location: intent.Location,
+	}, nil
+}
+
+func (w *World) territory(location gamelogic.Location) gamelogic.Territory {
+	territory, ok := w.territories[location]
+	if !ok {
+		return gamelogic.Territory{Location: location}
+	}
+	return territory
+}
+
+func (w *World) checkFortify(intent gamelogic.Intent) error {
+	if w.paused {
+		return fmt.Errorf("the game is paused, you can not fortify")
+	}
+	err := w.board.ValidateLocation(intent.Location)
+	if err != nil {
+		return err
+	}
+	w.player(intent.Username)
+	return w.board.CheckFortify(intent.Username, w.territory(intent.Location), w.treasury[intent.Username]-w.reserved[intent.Username])
+}
+
+func (w *World) fortify(intent gamelogic.Intent) (gamelogic.StateDelta, error) {
+	err := w.checkFortify(intent)
+	if err != nil {
+		return gamelogic.StateDelta{}, err
+	}
+
+	territory := w.territory(intent.Location)
+	territory.Forts++
+	w.territories[territory.Location] = territory
+	w.treasury[intent.Username] -= w.board.FortCost
+
+	return gamelogic.StateDelta{
+		Kind:      gamelogic.DeltaFortified,
+		Username:  intent.Username,
+		Location:  territory.Location,
+		Gold:      -w.board.FortCost,
+		Balance:   w.treasury[intent.Username],
+		Territory: &territory,
+	}, nil
+}
+
+// claim gives every territory only one player has units in to them. Forts
+// stay with the territory and defend whoever holds it.
+func (w *World) claim() []gamelogic.StateDelta {
+	present := map[gamelogic.Location][]string{}
+	for _, username := range w.usernames() {
+		for _, location := range unitLocations(w.players[username]) {
+			present[location] = append(present[location], username)
+		}
+	}
+
+	deltas := []gamelogic.StateDelta{}
+	for _, location := range w.board.Territories {
+		if len(present[location]) != 1 {
+			continue
+		}
+		territory := w.territory(location)
+		if territory.Owner == present[location][0] {
+			continue
+		}
+		territory.Owner = present[location][0]
+		w.territories[location] = territory
+		deltas = append(deltas, gamelogic.StateDelta{
+			Kind:      gamelogic.DeltaCaptured,
+			Username:  territory.Owner,
+			Location:  location,
+			Territory: &territory,
+		})
+	}
+	return deltas
+}
+
+// defense is what defender's power in location is multiplied by, which is
+// only more than 1 if they control it.
+func (w *World) defense(defender string, location gamelogic.Location) float64 {
+	territory := w.territory(location)
+	if territory.Owner != defender {
+		return 1
+	}
+	return w.board.DefenseOf(territory)
+}
+
+// checkPeace refuses to send units where a player with a truce or
+// non-aggression pact with username has units. Allies can share locations.
+func (w *World) checkPeace(username string, location gamelogic.Location) error {
+	for _, other := range w.usernames() {
+		if other == username || len(gamelogic.UnitsIn(*w.players[other], location)) == 0 {
+			continue
+		}
+		pact, ok := w.pacts.Between(username, other, time.Now())
+		if ok && pact.Kind != routing.PactAlliance {
+			return fmt.Errorf("you have a(n) %s with %s, who has units in %s, break it before attacking them", pact.Kind, other, location)
+		}
+	}
+	return nil
+}
+
+// fight makes the player who just arrived at location go to war with every
+// other player there, one at a time in username order, until they lose
+// their units there or nobody is left. Wars with the same player go on
+// until one side is gone, as not every war kills everyone.
+func (w *World) fight(attacker *gamelogic.Player, location gamelogic.Location) []gamelogic.StateDelta {
+	deltas := []gamelogic.StateDelta{}
+	for _, username := range w.usernames() {
+		defender := w.players[username]
+		if defender == attacker {
+			continue
+		}
+		// Players with a pact share locations in peace. Only allies get
+		// here on purpose, the others by ending up in the same place in
+		// one turn.
+		if _, ok := w.pacts.Between(attacker.Username, defender.Username, time.Now()); ok {
+			continue
+		}
+		for len(gamelogic.UnitsIn(*defender, location)) > 0 && len(gamelogic.UnitsIn(*attacker, location)) > 0 {
+			deltas = append(deltas, gamelogic.StateDelta{
+				Kind:     gamelogic.DeltaWarDeclared,
+				Username: attacker.Username,
+				Location: location,
+				War:      &gamelogic.WarResult{Attacker: attacker.Username, Defender: defender.Username, Location: location},
+			})
+			result := w.combat.Resolve(*attacker, *defender, location, w.defense(defender.Username, location))
+			deltas = append(deltas, gamelogic.StateDelta{
+				Kind:     gamelogic.DeltaWar,
+				Username: attacker.Username,
+				Location: location,
+				War:      &result,
+			})
+
+			losses := map[*gamelogic.Player][]gamelogic.Unit{attacker: result.AttackerLosses, defender: result.DefenderLosses}
+			removed := 0
+			for _, side := range []*gamelogic.Player{attacker, defender} {
+				lost := removeUnits(side, losses[side])
+				if len(lost) == 0 {
+					continue
+				}
+				removed += len(lost)
+				deltas = append(deltas, gamelogic.StateDelta{
+					Kind:     gamelogic.DeltaDestroyed,
+					Username: side.Username,
+					Units:    lost,
+					Location: location,
+				})
+			}
+			if removed == 0 {
+				// Nobody can be worn down, or the resolver named units
+				// that aren't there. Leave them be.
+				break
+			}
+		}
+	}
+	return deltas
+}
+
+// fightContested fights over every location more than one player is still
+// in. Each of them attacks in turn by username, players with pacts don't
+// fight each other.
+func (w *World) fightContested() []gamelogic.StateDelta {
+	present := map[gamelogic.Location][]string{}
+	for _, username := range w.usernames() {
+		for _, location := range unitLocations(w.players[username]) {
+			present[location] = append(present[location], username)
+		}
+	}
+	locations := []gamelogic.Location{}
+	for location, usernames := range present {
+		if len(usernames) > 1 {
+			locations = append(locations, location)
+		}
+	}
+	sort.Slice(locations, func(i, j int) bool { return locations[i] < locations[j] })
+
+	deltas := []gamelogic.StateDelta{}
+	for _, location := range locations {
+		// Allies of the first attacker still have to fight whoever is
+		// left.
+		for _, username := range present[location] {
+			deltas = append(deltas, w.fight(w.players[username], location)...)
+		}
+	}
+	return deltas
+}
+
+func (w *World) usernames() []string {
+	usernames := make([]string, 0, len(w.players))
+	for username := range w.players {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	return usernames
+}
+
+// removeUnits takes units away from a player and returns the ones they had,
+// sorted by ID.
+func removeUnits(player *gamelogic.Player, units []gamelogic.Unit) []gamelogic.Unit {
+	removed := []gamelogic.Unit{}
+	for _, unit := range units {
+		if _, ok := player.Units[unit.ID]; ok {
+			delete(player.Units, unit.ID)
+			removed = append(removed, unit)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].ID < removed[j].ID })
+	return removed
+}
+
+func unitLocations(player *gamelogic.Player) []gamelogic.Location {
+	seen := map[gamelogic.Location]bool{}
+	locations := []gamelogic.Location{}
+	for _, unit := range player.Units {
+		if !seen[unit.Location] {
+			seen[unit.Location] = true
+			locations = append(locations, unit.Location)
+		}
+	}
+	return locations
+}
+
+func sortedUnits(units map[int]gamelogic.Unit) []gamelogic.Unit {
+	sorted := make([]gamelogic.Unit, 0, len(units))
+	for _, unit := range units {
+		sorted = append(sorted, unit)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	return sorted
+}
+
+func copyPlayer(player *gamelogic.Player) gamelogic.Player {
+	units := make(map[int]gamelogic.Unit, len(player.Units))
+	for id, unit := range player.Units {
+		units[id] = unit
+	}
+	return gamelogic.Player{Username: player.Username, Units: units}
+}
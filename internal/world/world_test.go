@@ -0,0 +1,174 @@
+package world
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const testTimeout = 2 * time.Second
+
+func TestWorldOverBroker(t *testing.T) {
+	b := pubsub.NewMemoryBroker()
+	t.Cleanup(func() { b.Close() })
+	for name, kind := range map[string]string{
+		routing.ExchangePerilTopic: amqp.ExchangeTopic,
+		routing.ExchangePerilDLX:   amqp.ExchangeFanout,
+	} {
+		if err := b.DeclareExchange(name, kind); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gameWorld := New(gamelogic.DefaultMap())
+
+	server, err := pubsub.SubscribeJSON(b, routing.ExchangePerilTopic, routing.IntentsPrefix, routing.IntentsPrefix+".*", pubsub.QueueOptions{Type: pubsub.QueueTypeDurable}, func(intent gamelogic.Intent) pubsub.AckType {
+		for _, delta := range gameWorld.Handle(intent) {
+			err := pubsub.PublishJSON(b, routing.ExchangePerilTopic, routing.StatePrefix+"."+delta.Username, delta)
+			if err != nil {
+				t.Errorf("publishing delta: %v", err)
+			}
+		}
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	type client struct {
+		state  *gamelogic.GameState
+		deltas chan gamelogic.StateDelta
+	}
+	join := func(username string) client {
+		c := client{state: gamelogic.NewGameState(username), deltas: make(chan gamelogic.StateDelta, 16)}
+		queueName := routing.StatePrefix + "." + username
+		sub, err := pubsub.SubscribeJSON(b, routing.ExchangePerilTopic, queueName, queueName, pubsub.QueueOptions{Type: pubsub.QueueTypeTransient}, func(delta gamelogic.StateDelta) pubsub.AckType {
+			c.state.ApplyDelta(delta)
+			c.deltas <- delta
+			return pubsub.Ack
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { sub.Close() })
+		return c
+	}
+	send := func(intent gamelogic.Intent) {
+		t.Helper()
+		err := pubsub.PublishJSON(b, routing.ExchangePerilTopic, routing.IntentsPrefix+"."+intent.Username, intent)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	wait := func(c client, kind gamelogic.DeltaKind) gamelogic.StateDelta {
+		t.Helper()
+		for {
+			select {
+			case delta := <-c.deltas:
+				if delta.Kind == kind {
+					return delta
+				}
+				if delta.Kind == gamelogic.DeltaRejected {
+					t.Fatalf("%s's intent was rejected: %s", delta.Username, delta.Reason)
+				}
+			case <-time.After(testTimeout):
+				t.Fatalf("%s got no %s delta", c.state.GetUsername(), kind)
+			}
+		}
+	}
+
+	alice, bob := join("alice"), join("bob")
+	send(gamelogic.Intent{ID: "a1", Kind: gamelogic.IntentJoin, Username: "alice"})
+	send(gamelogic.Intent{ID: "b1", Kind: gamelogic.IntentJoin, Username: "bob"})
+	wait(alice, gamelogic.DeltaSnapshot)
+	wait(bob, gamelogic.DeltaSnapshot)
+
+	send(gamelogic.Intent{ID: "a2", Kind: gamelogic.IntentSpawn, Username: "alice", Rank: gamelogic.RankArtillery, Location: "europe"})
+	spawned := wait(alice, gamelogic.DeltaSpawned)
+	if spawned.IntentID != "a2" || len(alice.state.GetPlayerSnap().Units) != 1 {
+		t.Fatalf("alice has %d units after spawning", len(alice.state.GetPlayerSnap().Units))
+	}
+
+	send(gamelogic.Intent{ID: "b2", Kind: gamelogic.IntentSpawn, Username: "bob", Rank: gamelogic.RankInfantry, Location: "asia"})
+	unit := wait(bob, gamelogic.DeltaSpawned).Units[0]
+	send(gamelogic.Intent{ID: "b3", Kind: gamelogic.IntentMove, Username: "bob", Location: "europe", UnitIDs: []int{unit.ID}})
+
+	// Bob's infantry walks into alice's artillery and loses.
+	war := wait(bob, gamelogic.DeltaWar).War
+	if war.Attacker != "bob" || war.Defender != "alice" || war.Location != "europe" || war.Winner != "alice" {
+		t.Errorf("bob saw %s attack %s in %s and %q win", war.Attacker, war.Defender, war.Location, war.Winner)
+	}
+	wait(bob, gamelogic.DeltaDestroyed)
+	if units := bob.state.GetPlayerSnap().Units; len(units) != 0 {
+		t.Errorf("bob has %d units after losing, want 0", len(units))
+	}
+	if units := alice.state.GetPlayerSnap().Units; len(units) != 1 {
+		t.Errorf("alice has %d units after winning, want 1", len(units))
+	}
+	if n := b.QueueLength(routing.IntentsPrefix); n != 0 {
+		t.Errorf("%d intents were left unhandled", n)
+	}
+}
+
+// handle sends an intent to w and fails the test if it is refused.
+func handle(t *testing.T, w *World, intent gamelogic.Intent) []gamelogic.StateDelta {
+	t.Helper()
+	deltas := w.Handle(intent)
+	for _, delta := range deltas {
+		if delta.Kind == gamelogic.DeltaRejected {
+			t.Fatalf("%s %s was refused: %s", intent.Username, intent.Kind, delta.Reason)
+		}
+	}
+	return deltas
+}
+
+func spawn(username string, rank gamelogic.UnitRank, location gamelogic.Location) gamelogic.Intent {
+	return gamelogic.Intent{Kind: gamelogic.IntentSpawn, Username: username, Rank: rank, Location: location}
+}
+
+func TestWorldEconomy(t *testing.T) {
+	board := gamelogic.DefaultMap()
+	w := New(board)
+	handle(t, w, gamelogic.Intent{Kind: gamelogic.IntentJoin, Username: "alice"})
+	if balance := w.Balance("alice"); balance != board.StartingGold {
+		t.Fatalf("alice starts with %d gold, want %d", balance, board.StartingGold)
+	}
+
+	handle(t, w, spawn("alice", gamelogic.RankArtillery, "europe"))
+	want := board.StartingGold - board.Cost(gamelogic.RankArtillery)
+	if balance := w.Balance("alice"); balance != want {
+		t.Errorf("alice has %d gold after buying artillery, want %d", balance, want)
+	}
+	deltas := w.Handle(spawn("alice", gamelogic.RankCavalry, "europe"))
+	if len(deltas) != 1 || deltas[0].Kind != gamelogic.DeltaRejected || w.Balance("alice") != want {
+		t.Errorf("alice bought cavalry without the gold for it: %+v", deltas)
+	}
+
+	deltas = w.PayIncome()
+	want += board.Income
+	if len(deltas) != 1 || deltas[0].Kind != gamelogic.DeltaIncome || deltas[0].Gold != board.Income || deltas[0].Balance != want {
+		t.Fatalf("income for europe: %+v", deltas)
+	}
+
+	// Europe stays alice's after the artillery leaves, so both pay.
+	artillery := w.Players()[0].Units
+	ids := []int{}
+	for id := range artillery {
+		ids = append(ids, id)
+	}
+	handle(t, w, gamelogic.Intent{Kind: gamelogic.IntentMove, Username: "alice", Location: "asia", UnitIDs: ids})
+	w.PayIncome()
+	want += 2 * board.Income
+	if balance := w.Balance("alice"); balance != want {
+		t.Errorf("alice has %d gold after holding europe and asia, want %d", balance, want)
+	}
+
+	w.SetPaused(true)
+	if deltas := w.PayIncome(); len(deltas) != 0 {
+		t.Errorf("income was paid while paused: %+v", deltas)
+	}
+}
@@ -13,7 +13,29 @@ case "$1" in
         echo "Fetching logs for RabbitMQ container..."
         docker logs -f rabbitmq
         ;;
+    adduser)
+        # Players log in as their own user, which may only declare and read
+        # their own queues and only publish with their own name in the key.
+        # Nothing but the server may publish to the default exchange, the
+        # retry queues behind it are trusted to hold the server's retries.
+        user="$2"
+        password="$3"
+        if [[ -z "$user" || -z "$password" || ! "$user" =~ ^[A-Za-z0-9_-]+$ ]]; then
+            echo "Usage: $0 adduser <username> <password>, usernames are letters, digits, '_' and '-'"
+            exit 1
+        fi
+        queues="(pause|turn|game_over|state|diplomacy|chat)\\.$user"
+        echo "Adding RabbitMQ user $user..."
+        docker exec rabbitmq rabbitmqctl add_user "$user" "$password"
+        docker exec rabbitmq rabbitmqctl set_permissions -p / "$user" \
+            "^$queues\$" \
+            "^$queues\$|^peril_(topic|dlx)\$" \
+            "^$queues\$|^peril_(direct|topic)\$"
+        docker exec rabbitmq rabbitmqctl set_topic_permissions -p / "$user" peril_topic \
+            "^(intents|chat\\.send|game_logs)\\.$user\$|^diplomacy\\.[^.]+\\.$user\$" \
+            "^(state\\.\\*|state\\.$user\\.private|chat\\.global|chat\\.(alliance|direct)\\.$user|diplomacy\\.$user\\.\\*)\$"
+        ;;
     *)
-        echo "Usage: $0 {start|stop|logs}"
+        echo "Usage: $0 {start|stop|logs|adduser <username> <password>}"
         exit 1
 esac